@@ -2,8 +2,11 @@ package main
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"io"
+	"log/slog"
+	"net/http"
 	"os"
 	"strings"
 	"time"
@@ -11,7 +14,6 @@ import (
 	ai "github.com/bharathcs/go-ai-utils/lib"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
-	"github.com/openai/openai-go"
 )
 
 type State int
@@ -24,13 +26,30 @@ const (
 
 type CommandSolution struct {
 	Command   string `json:"command"`
-	Relevance int    `json:"relevance"` // 1-3, where 3 is most relevant
+	Relevance int    `json:"relevance" jsonschema:"description=How relevant the command is to the query (3 is most relevant),enum=1|2|3"`
 }
 
 type CommandSolutions struct {
 	Solutions []CommandSolution `json:"solutions"`
 }
 
+// Validate rejects responses with no solutions, blank commands or out-of-range
+// relevance so the model is asked to correct them
+func (s CommandSolutions) Validate() error {
+	if len(s.Solutions) == 0 {
+		return fmt.Errorf("at least one solution is required")
+	}
+	for i, sol := range s.Solutions {
+		if strings.TrimSpace(sol.Command) == "" {
+			return fmt.Errorf("solution %d has an empty command", i+1)
+		}
+		if sol.Relevance < 1 || sol.Relevance > 3 {
+			return fmt.Errorf("solution %d has relevance %d, must be between 1 and 3", i+1, sol.Relevance)
+		}
+	}
+	return nil
+}
+
 type apiResponseMsg struct {
 	solutions []CommandSolution
 }
@@ -48,9 +67,8 @@ type model struct {
 	selectedSolution int
 	loadingFrame     int
 	conversation     *ai.Conversation
-	aiClient         *openai.Client
-	aiConfig         *ai.Config
-	pipedContext     string // Context from piped stdin
+	pipedContext     string          // Context from piped stdin
+	pipedImage       *ai.ContentPart // Image piped to stdin, attached to the first query
 }
 
 var (
@@ -79,12 +97,13 @@ var (
 			Bold(true)
 )
 
-func initialModel(pipedContext string) model {
+func initialModel(pipedContext string, pipedImage *ai.ContentPart, logger *slog.Logger) model {
 	client, config, err := ai.NewClientFromEnv()
 	var conversation *ai.Conversation
 	var history []string
 
 	if err == nil {
+		config.Logger = logger
 		systemPrompt := `You are a helpful command-line assistant. When users ask for help with commands, provide up to 3 specific, working command snippets. Each solution should only include the exact command and a relevance rating (3=most relevant, 1=least relevant). Focus on practical, commonly-used commands that can be executed immediately. Provide fewer solutions if 1-2 commands are sufficient.`
 		conversation = ai.NewConversation(client, config, systemPrompt)
 	} else {
@@ -109,6 +128,13 @@ func initialModel(pipedContext string) model {
 		)
 	}
 
+	if pipedImage != nil {
+		history = append(history,
+			lipgloss.NewStyle().Foreground(lipgloss.Color("214")).Render(":frame_with_picture: Image from stdin attached"),
+			"",
+		)
+	}
+
 	return model{
 		state:            StateInput,
 		input:            "",
@@ -118,9 +144,8 @@ func initialModel(pipedContext string) model {
 		selectedSolution: -1,
 		loadingFrame:     0,
 		conversation:     conversation,
-		aiClient:         client,
-		aiConfig:         config,
 		pipedContext:     pipedContext,
+		pipedImage:       pipedImage,
 	}
 }
 
@@ -142,16 +167,21 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					m.history = append(m.history, "> "+m.input)
 					prompt := m.input
 
-					// Add piped context if available
-					if m.pipedContext != "" {
+					// Add piped context to the first query; later ones see it in the conversation
+					firstQuery := m.conversation == nil || len(m.conversation.GetHistory()) <= 1
+					if m.pipedContext != "" && firstQuery {
 						prompt = fmt.Sprintf("Context:\n```\n%s\n```\n\nQuery: %s", m.pipedContext, prompt)
 					}
+					var attachments []ai.ContentPart
+					if m.pipedImage != nil && firstQuery {
+						attachments = append(attachments, *m.pipedImage)
+					}
 
 					m.input = ""
 					m.cursor = 0
 					m.state = StateLoading
 					m.selectedSolution = -1
-					return m, tea.Batch(callAPI(prompt, m.aiClient, m.aiConfig), tickLoading())
+					return m, tea.Batch(callAPI(prompt, m.conversation, attachments...), tickLoading())
 				}
 			} else if m.state == StateShowingSolutions && m.selectedSolution >= 0 {
 				// User selected a solution - execute it (type it out)
@@ -296,37 +326,47 @@ func tickLoading() tea.Cmd {
 	})
 }
 
-// API call to get command suggestions using structured output
-func callAPI(prompt string, client *openai.Client, config *ai.Config) tea.Cmd {
+// sessionID is the correlation ID tying together the log lines of one run
+var sessionID = ai.NewCorrelationID()
+
+// newLogger returns a JSON logger writing to the file named by IDK_LOG_FILE,
+// or nil if it isn't set; the TUI owns the terminal, so logs can't go to
+// stderr. IDK_LOG_LEVEL=trace also logs prompts and responses.
+func newLogger() (*slog.Logger, io.Closer, error) {
+	path := os.Getenv("IDK_LOG_FILE")
+	if path == "" {
+		return nil, nil, nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to open log file: %w", err)
+	}
+
+	level := slog.LevelDebug
+	if strings.EqualFold(os.Getenv("IDK_LOG_LEVEL"), "trace") {
+		level = ai.LevelTrace
+	}
+	return slog.New(slog.NewJSONHandler(file, &slog.HandlerOptions{Level: level})), file, nil
+}
+
+// API call to get command suggestions using structured output. Each query is a
+// turn in the conversation, so follow-ups can refine earlier suggestions.
+func callAPI(prompt string, conversation *ai.Conversation, attachments ...ai.ContentPart) tea.Cmd {
 	return func() tea.Msg {
 		// Check if client is available
-		if client == nil || config == nil {
+		if conversation == nil {
 			return apiErrorMsg{err: fmt.Errorf("AI client not configured. Please set OPENAI_API_KEY environment variable")}
 		}
 
 		// Create context with timeout to handle network issues
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		ctx, cancel := context.WithTimeout(ai.WithCorrelationID(context.Background(), sessionID), 30*time.Second)
 		defer cancel()
 
 		var result CommandSolutions
-		systemPrompt := "You are a helpful command-line assistant. Provide up to 3 specific, working command snippets. Each solution should only include the exact command and a relevance rating (3=most relevant, 1=least relevant). Provide fewer solutions if 1-2 commands are sufficient."
-
-		err := ai.StructuredQueryFromEnv(ctx, prompt, systemPrompt, &result)
+		err := conversation.SendStructuredWithAttachments(ctx, prompt, &result, attachments...)
 		if err != nil {
-			// Provide more specific error messages
-			if ctx.Err() == context.DeadlineExceeded {
-				return apiErrorMsg{err: fmt.Errorf("request timed out after 30 seconds. Please check your network connection")}
-			}
-			if strings.Contains(err.Error(), "401") || strings.Contains(err.Error(), "authentication") || strings.Contains(err.Error(), "Forbidden") {
-				return apiErrorMsg{err: fmt.Errorf("authentication failed. Please check your OPENAI_API_KEY")}
-			}
-			if strings.Contains(err.Error(), "429") || strings.Contains(err.Error(), "rate limit") {
-				return apiErrorMsg{err: fmt.Errorf("rate limit exceeded. Please wait a moment and try again")}
-			}
-			if strings.Contains(err.Error(), "500") || strings.Contains(err.Error(), "502") || strings.Contains(err.Error(), "503") {
-				return apiErrorMsg{err: fmt.Errorf("server error. Please try again later")}
-			}
-			return apiErrorMsg{err: fmt.Errorf("API error: %v", err)}
+			return apiErrorMsg{err: friendlyError(ctx, err)}
 		}
 
 		// Validate solutions
@@ -353,6 +393,37 @@ func callAPI(prompt string, client *openai.Client, config *ai.Config) tea.Cmd {
 	}
 }
 
+// friendlyError maps lib errors to actionable messages for the user
+func friendlyError(ctx context.Context, err error) error {
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("request timed out after 30 seconds. Please check your network connection")
+	}
+
+	var validationErr *ai.ValidationError
+	var authErr *ai.AuthError
+	var rateLimitErr *ai.RateLimitError
+	var serverErr *ai.ServerError
+	switch {
+	case errors.As(err, &validationErr):
+		return fmt.Errorf("no valid solutions found. Please try rephrasing your query")
+	case errors.As(err, &authErr):
+		return fmt.Errorf("authentication failed. Please check your OPENAI_API_KEY")
+	case errors.As(err, &rateLimitErr):
+		return fmt.Errorf("rate limit exceeded. Please wait a moment and try again")
+	case errors.As(err, &serverErr):
+		return fmt.Errorf("server error. Please try again later")
+	case errors.Is(err, ai.ErrRefusal):
+		return fmt.Errorf("the AI declined to answer this query")
+	case errors.Is(err, ai.ErrContentFilter):
+		return fmt.Errorf("the response was blocked by the content filter. Please rephrase your query")
+	case errors.Is(err, ai.ErrTruncated):
+		return fmt.Errorf("the response was too long and got cut off. Please try a more specific query")
+	case errors.Is(err, ai.ErrEmptyContent), errors.Is(err, ai.ErrNoChoices):
+		return fmt.Errorf("the AI returned an empty response. Please try again")
+	}
+	return fmt.Errorf("API error: %v", err)
+}
+
 // Execute solution - types out the selected command
 func executeSolution(solution string) tea.Cmd {
 	return func() tea.Msg {
@@ -375,35 +446,52 @@ func executeSolution(solution string) tea.Cmd {
 	}
 }
 
-func readPipedInput(maxLen int) string {
+// maxPipedImageBytes is the largest image accepted on stdin
+const maxPipedImageBytes = 20 << 20
+
+func readPipedInput(maxLen int) (string, *ai.ContentPart) {
 	stat, err := os.Stdin.Stat()
 	if err != nil {
-		return ""
+		return "", nil
 	}
 
 	if (stat.Mode() & os.ModeCharDevice) == 0 {
-		limitedReader := io.LimitReader(os.Stdin, int64(maxLen+1))
+		limitedReader := io.LimitReader(os.Stdin, maxPipedImageBytes+1)
 		data, err := io.ReadAll(limitedReader)
 		if err != nil {
-			return ""
+			return "", nil
 		}
 
-		content := strings.TrimSpace(string(data))
+		return parsePipedInput(data, maxLen)
+	}
+
+	return "", nil
+}
 
-		if len(content) > maxLen {
-			content = content[:maxLen] + "\n... (truncated)"
+// parsePipedInput returns piped images as an attachment and anything else as
+// text context, truncated to maxLen
+func parsePipedInput(data []byte, maxLen int) (string, *ai.ContentPart) {
+	if mediaType := http.DetectContentType(data); strings.HasPrefix(mediaType, "image/") {
+		if len(data) > maxPipedImageBytes {
+			return "", nil
 		}
+		image := ai.ImageData(data, mediaType)
+		return "", &image
+	}
 
-		return content
+	content := strings.TrimSpace(string(data))
+
+	if len(content) > maxLen {
+		content = content[:maxLen] + "\n... (truncated)"
 	}
 
-	return ""
+	return content, nil
 }
 
 func main() {
-	pipedContext := readPipedInput(400)
+	pipedContext, pipedImage := readPipedInput(400)
 
-	if pipedContext != "" {
+	if pipedContext != "" || pipedImage != nil {
 		tty, err := os.Open("/dev/tty")
 		if err != nil {
 			fmt.Printf("Error: Cannot open /dev/tty for interactive input: %v\n", err)
@@ -412,7 +500,16 @@ func main() {
 		os.Stdin = tty
 	}
 
-	p := tea.NewProgram(initialModel(pipedContext))
+	logger, logFile, err := newLogger()
+	if err != nil {
+		fmt.Printf("Error: %v\n", err)
+		os.Exit(1)
+	}
+	if logFile != nil {
+		defer logFile.Close()
+	}
+
+	p := tea.NewProgram(initialModel(pipedContext, pipedImage, logger))
 	if _, err := p.Run(); err != nil {
 		fmt.Printf("Error: %v\n", err)
 		os.Exit(1)
@@ -1,9 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
+	ai "github.com/bharathcs/go-ai-utils/lib"
+	"github.com/bharathcs/go-ai-utils/lib/testutil"
 	tea "github.com/charmbracelet/bubbletea"
 )
 
@@ -35,7 +42,7 @@ func TestInitialModel(t *testing.T) {
 
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			m := initialModel(tt.pipedContext)
+			m := initialModel(tt.pipedContext, nil, nil)
 			if m.state != tt.wantState {
 				t.Errorf("initialModel() state = %v, want %v", m.state, tt.wantState)
 			}
@@ -388,7 +395,7 @@ func TestCommandSolution(t *testing.T) {
 }
 
 func TestModelInit(t *testing.T) {
-	m := initialModel("")
+	m := initialModel("", nil, nil)
 	cmd := m.Init()
 	if cmd != nil {
 		t.Error("Init() should return nil")
@@ -539,3 +546,240 @@ func TestMultipleNavigationCycles(t *testing.T) {
 		}
 	}
 }
+
+func TestCommandSolutionsValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		sols    CommandSolutions
+		wantErr bool
+	}{
+		{
+			name: "valid",
+			sols: CommandSolutions{Solutions: []CommandSolution{{Command: "ls", Relevance: 3}}},
+		},
+		{
+			name:    "no solutions",
+			sols:    CommandSolutions{},
+			wantErr: true,
+		},
+		{
+			name:    "empty command",
+			sols:    CommandSolutions{Solutions: []CommandSolution{{Command: "  ", Relevance: 2}}},
+			wantErr: true,
+		},
+		{
+			name:    "relevance out of range",
+			sols:    CommandSolutions{Solutions: []CommandSolution{{Command: "ls", Relevance: 5}}},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.sols.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestFriendlyError(t *testing.T) {
+	tests := []struct {
+		name       string
+		err        error
+		wantSubstr string
+	}{
+		{"auth", &ai.AuthError{APIError: ai.APIError{StatusCode: 401}}, "authentication failed"},
+		{"rate limit", &ai.RateLimitError{APIError: ai.APIError{StatusCode: 429}}, "rate limit exceeded"},
+		{"server", &ai.ServerError{APIError: ai.APIError{StatusCode: 502}}, "server error"},
+		{"validation", &ai.ValidationError{Attempts: []ai.ValidationAttempt{{Err: errors.New("bad")}}}, "no valid solutions"},
+		{"refusal", &ai.ResponseError{Err: ai.ErrRefusal, Refusal: "I can't help with that"}, "declined"},
+		{"truncated", &ai.ResponseError{Err: ai.ErrTruncated}, "cut off"},
+		{"content filter", &ai.ResponseError{Err: ai.ErrContentFilter}, "content filter"},
+		{"empty", &ai.ResponseError{Err: ai.ErrEmptyContent}, "empty response"},
+		{"other", errors.New("something odd"), "API error: something odd"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := friendlyError(context.Background(), tt.err)
+			if !strings.Contains(got.Error(), tt.wantSubstr) {
+				t.Errorf("friendlyError() = %q, want substring %q", got.Error(), tt.wantSubstr)
+			}
+		})
+	}
+}
+
+func TestFriendlyError_Timeout(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	<-ctx.Done()
+
+	got := friendlyError(ctx, errors.New("context deadline exceeded"))
+	if !strings.Contains(got.Error(), "timed out") {
+		t.Errorf("friendlyError() = %q, want timeout message", got.Error())
+	}
+}
+
+// scriptedProvider replays canned responses and records the requests it receives
+type scriptedProvider struct {
+	requests  []*ai.Request
+	responses []string
+}
+
+func (p *scriptedProvider) Complete(ctx context.Context, req *ai.Request) (*ai.Response, error) {
+	p.requests = append(p.requests, req)
+	content := p.responses[0]
+	p.responses = p.responses[1:]
+	return &ai.Response{ID: "test", Content: content, FinishReason: "stop"}, nil
+}
+
+func TestCallAPI_UsesConversation(t *testing.T) {
+	provider := &scriptedProvider{responses: []string{
+		`{"solutions":[{"command":"ls -la","relevance":3}]}`,
+		`{"solutions":[{"command":"ls -lah","relevance":3}]}`,
+	}}
+	conversation := ai.NewConversation(provider, ai.DefaultConfig(), "system")
+
+	msg := callAPI("list files", conversation)()
+	if resp, ok := msg.(apiResponseMsg); !ok || resp.solutions[0].Command != "ls -la" {
+		t.Fatalf("callAPI() = %+v, want ls -la", msg)
+	}
+
+	msg = callAPI("with human readable sizes", conversation)()
+	if resp, ok := msg.(apiResponseMsg); !ok || resp.solutions[0].Command != "ls -lah" {
+		t.Fatalf("callAPI() = %+v, want ls -lah", msg)
+	}
+
+	// The follow-up is sent with the earlier query and answer as context
+	followUp := provider.requests[1]
+	if len(followUp.Messages) != 4 || followUp.Messages[1].Content != "list files" || followUp.Schema == nil {
+		t.Errorf("follow-up request = %+v, want conversation context and a schema", followUp)
+	}
+}
+
+func TestCallAPI_Logging(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "idk.log")
+	t.Setenv("IDK_LOG_FILE", path)
+	t.Setenv("IDK_LOG_LEVEL", "")
+
+	logger, logFile, err := newLogger()
+	if err != nil {
+		t.Fatalf("newLogger() error = %v", err)
+	}
+	defer logFile.Close()
+
+	provider := &scriptedProvider{responses: []string{`{"solutions":[{"command":"ls -la","relevance":3}]}`}}
+	config := ai.DefaultConfig()
+	config.Logger = logger
+	callAPI("list files", ai.NewConversation(provider, config, "system"))()
+
+	data, _ := os.ReadFile(path)
+	log := string(data)
+	if !strings.Contains(log, `"msg":"ai request completed"`) || !strings.Contains(log, `"correlation_id":"`+sessionID+`"`) {
+		t.Errorf("log = %s, want a completion line with the session ID", log)
+	}
+	if strings.Contains(log, "list files") {
+		t.Errorf("log = %s, want no prompts below trace level", log)
+	}
+}
+
+func TestNewLogger_Disabled(t *testing.T) {
+	t.Setenv("IDK_LOG_FILE", "")
+	if logger, logFile, err := newLogger(); logger != nil || logFile != nil || err != nil {
+		t.Errorf("newLogger() = %v, %v, %v, want no logger", logger, logFile, err)
+	}
+}
+
+func TestCallAPI_NotConfigured(t *testing.T) {
+	msg := callAPI("list files", nil)()
+	if _, ok := msg.(apiErrorMsg); !ok {
+		t.Errorf("callAPI() = %+v, want apiErrorMsg", msg)
+	}
+}
+
+func TestParsePipedInput(t *testing.T) {
+	png := []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+	text, image := parsePipedInput(png, 400)
+	if text != "" || image == nil || image.Type != ai.PartImage || !strings.HasPrefix(image.URL, "data:image/png;base64,") {
+		t.Errorf("parsePipedInput(png) = %q, %+v, want image attachment", text, image)
+	}
+
+	text, image = parsePipedInput([]byte("  error: file not found\n"), 400)
+	if text != "error: file not found" || image != nil {
+		t.Errorf("parsePipedInput(text) = %q, %+v, want trimmed text", text, image)
+	}
+
+	text, _ = parsePipedInput([]byte(strings.Repeat("a", 20)), 10)
+	if text != "aaaaaaaaaa\n... (truncated)" {
+		t.Errorf("parsePipedInput(long) = %q, want truncated text", text)
+	}
+}
+
+func TestCallAPI_Attachments(t *testing.T) {
+	provider := &scriptedProvider{responses: []string{`{"solutions":[{"command":"fix","relevance":3}]}`}}
+	conversation := ai.NewConversation(provider, ai.DefaultConfig(), "system")
+
+	image := ai.ImageData([]byte("\x89PNG\r\n\x1a\n"), "")
+	callAPI("what went wrong?", conversation, image)()
+
+	if parts := provider.requests[0].Messages[1].Parts; len(parts) != 1 || parts[0].URL != image.URL {
+		t.Errorf("request parts = %+v, want the piped image", parts)
+	}
+}
+
+func TestCallAPI_FakeServer(t *testing.T) {
+	fake := testutil.NewFakeServer(t)
+	fake.UseEnv(t)
+	fake.Enqueue(testutil.FakeResponse{JSON: CommandSolutions{Solutions: []CommandSolution{
+		{Command: "git log --oneline", Relevance: 3},
+		{Command: "git log", Relevance: 2},
+	}}})
+
+	m := initialModel("", nil, nil)
+	if m.conversation == nil {
+		t.Fatal("initialModel() should configure the client from the environment")
+	}
+
+	msg := callAPI("show recent commits", m.conversation)()
+	resp, ok := msg.(apiResponseMsg)
+	if !ok || len(resp.solutions) != 2 || resp.solutions[0].Command != "git log --oneline" {
+		t.Fatalf("callAPI() = %+v, want the scripted solutions", msg)
+	}
+
+	req := fake.Requests()[0]
+	if req.Schema == nil || req.Messages[len(req.Messages)-1].Content != "show recent commits" {
+		t.Errorf("request = %+v, want a structured query for the prompt", req.Request)
+	}
+}
+
+func TestCallAPI_FakeServerErrors(t *testing.T) {
+	tests := []struct {
+		name       string
+		response   testutil.FakeResponse
+		wantSubstr string
+	}{
+		{"auth", testutil.FakeResponse{Status: http.StatusUnauthorized}, "authentication failed"},
+		{"refusal", testutil.FakeResponse{Refusal: "I can't help with that"}, "declined"},
+		{"no solutions", testutil.FakeResponse{JSON: CommandSolutions{}}, "no valid solutions"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			fake := testutil.NewFakeServer(t)
+			fake.UseEnv(t)
+			// Validation failures are repaired before giving up
+			fake.HandleFunc(func(req *testutil.FakeRequest) testutil.FakeResponse {
+				return tt.response
+			})
+
+			msg := callAPI("do something", initialModel("", nil, nil).conversation)()
+			errMsg, ok := msg.(apiErrorMsg)
+			if !ok || !strings.Contains(errMsg.err.Error(), tt.wantSubstr) {
+				t.Errorf("callAPI() = %+v, want error containing %q", msg, tt.wantSubstr)
+			}
+		})
+	}
+}
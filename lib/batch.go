@@ -0,0 +1,144 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+)
+
+// BatchOption configures a structured batch
+type BatchOption func(*batchOptions)
+
+type batchOptions struct {
+	workers     int
+	itemRetries int
+	limiter     *RateLimiter
+}
+
+// WithWorkers sets how many items are queried concurrently (default 4)
+func WithWorkers(workers int) BatchOption {
+	return func(o *batchOptions) {
+		o.workers = workers
+	}
+}
+
+// WithItemRetries sets how many times a failed item is run again from
+// scratch, on top of the HTTP retries in Config.Retry (default 1)
+func WithItemRetries(retries int) BatchOption {
+	return func(o *batchOptions) {
+		o.itemRetries = retries
+	}
+}
+
+// WithRateLimit limits the batch to requestsPerMinute requests and
+// tokensPerMinute tokens. Zero disables a limit. Token use is reserved from
+// a local estimate and corrected with the reported usage.
+func WithRateLimit(requestsPerMinute, tokensPerMinute int) BatchOption {
+	return func(o *batchOptions) {
+		o.limiter = NewRateLimiter(requestsPerMinute, tokensPerMinute)
+	}
+}
+
+// WithRateLimiter shares limiter between batches, or with other callers
+func WithRateLimiter(limiter *RateLimiter) BatchOption {
+	return func(o *batchOptions) {
+		o.limiter = limiter
+	}
+}
+
+// BatchResult is the outcome of one batch item
+type BatchResult[T any] struct {
+	// Index is the position of the item's prompt
+	Index int
+	Value T
+	Err   error
+	// Attempts is how many times the item was run
+	Attempts int
+	Usage    Usage
+}
+
+// StructuredBatchFromEnv runs StructuredBatch with a client built once from
+// environment variables
+func StructuredBatchFromEnv[T any](ctx context.Context, systemPrompt string, prompts []string, opts ...BatchOption) ([]BatchResult[T], Usage, error) {
+	client, config, err := NewClientFromEnv()
+	if err != nil {
+		return nil, Usage{}, err
+	}
+
+	results, usage := StructuredBatch[T](ctx, client, config, systemPrompt, prompts, opts...)
+	return results, usage, nil
+}
+
+// StructuredBatch runs a structured query for each prompt on a pool of
+// workers and decodes each response into a T. Results are returned in prompt
+// order; a failed item carries its error and doesn't stop the others. The
+// returned usage is the total across all items and attempts. If ctx is
+// cancelled, items not yet finished fail with ctx's error. Each item is logged
+// with the correlation ID "<batch ID>/<index>", where the batch ID is taken
+// from ctx or generated.
+func StructuredBatch[T any](ctx context.Context, client Provider, config *Config, systemPrompt string, prompts []string, opts ...BatchOption) ([]BatchResult[T], Usage) {
+	options := batchOptions{workers: 4, itemRetries: 1}
+	for _, opt := range opts {
+		opt(&options)
+	}
+	if options.limiter != nil {
+		client = &rateLimitedProvider{Provider: client, limiter: options.limiter}
+	}
+
+	ctx = ensureCorrelationID(ctx)
+	results := make([]BatchResult[T], len(prompts))
+	items := make(chan int)
+	var wg sync.WaitGroup
+	for range max(options.workers, 1) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range items {
+				itemCtx := WithCorrelationID(ctx, fmt.Sprintf("%s/%d", CorrelationID(ctx), i))
+				results[i] = runBatchItem[T](itemCtx, client, config, systemPrompt, options.itemRetries, i, prompts[i])
+			}
+		}()
+	}
+
+	for i := range prompts {
+		items <- i
+	}
+	close(items)
+	wg.Wait()
+
+	var usage Usage
+	for _, result := range results {
+		usage = usage.Add(result.Usage)
+	}
+	return results, usage
+}
+
+// runBatchItem runs one structured query, retrying failures that another
+// attempt might fix
+func runBatchItem[T any](ctx context.Context, client Provider, config *Config, systemPrompt string, retries, index int, prompt string) BatchResult[T] {
+	result := BatchResult[T]{Index: index}
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	options := structuredOptions{config: config, systemPrompt: systemPrompt, usage: &result.Usage}
+
+	for {
+		if err := ctx.Err(); err != nil {
+			result.Err = err
+			return result
+		}
+
+		result.Attempts++
+		var value T
+		result.Err = runStructured(ctx, client, options, prompt, t, &value)
+		if result.Err == nil {
+			result.Value = value
+			return result
+		}
+
+		var authErr *AuthError
+		if result.Attempts > retries || errors.As(result.Err, &authErr) || ctx.Err() != nil {
+			return result
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// funcProvider answers each request with fn; fn must be safe for concurrent use
+type funcProvider func(ctx context.Context, req *Request) (*Response, error)
+
+func (f funcProvider) Complete(ctx context.Context, req *Request) (*Response, error) {
+	return f(ctx, req)
+}
+
+func batchPrompts(n int) []string {
+	prompts := make([]string, n)
+	for i := range prompts {
+		prompts[i] = strconv.Itoa(i)
+	}
+	return prompts
+}
+
+func TestStructuredBatch_OrderedResults(t *testing.T) {
+	var inFlight, peak atomic.Int32
+	provider := funcProvider(func(ctx context.Context, req *Request) (*Response, error) {
+		current := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			old := peak.Load()
+			if current <= old || peak.CompareAndSwap(old, current) {
+				break
+			}
+		}
+
+		prompt := req.Messages[len(req.Messages)-1].Content
+		n, _ := strconv.Atoi(prompt)
+		time.Sleep(time.Duration(n%3) * time.Millisecond)
+		return &Response{ID: prompt, Content: fmt.Sprintf(`{"answer":%q,"score":%d}`, prompt, n), FinishReason: "stop", Usage: Usage{TotalTokens: 10}}, nil
+	})
+
+	prompts := batchPrompts(25)
+	results, usage := StructuredBatch[structuredAnswer](context.Background(), provider, DefaultConfig(), "Extract", prompts, WithWorkers(3))
+
+	if len(results) != len(prompts) {
+		t.Fatalf("Expected %d results, got %d", len(prompts), len(results))
+	}
+	for i, result := range results {
+		if result.Err != nil || result.Index != i || result.Value.Answer != prompts[i] || result.Attempts != 1 {
+			t.Errorf("Unexpected result %d: %+v", i, result)
+		}
+	}
+	if usage.TotalTokens != 250 {
+		t.Errorf("Expected 250 total tokens, got %d", usage.TotalTokens)
+	}
+	if peak.Load() > 3 {
+		t.Errorf("Expected at most 3 concurrent requests, got %d", peak.Load())
+	}
+}
+
+func TestStructuredBatch_CorrelationIDs(t *testing.T) {
+	var mu sync.Mutex
+	seen := map[string]string{}
+	provider := funcProvider(func(ctx context.Context, req *Request) (*Response, error) {
+		mu.Lock()
+		seen[req.Messages[len(req.Messages)-1].Content] = CorrelationID(ctx)
+		mu.Unlock()
+		return &Response{Content: `{"answer":"a","score":1}`, FinishReason: "stop"}, nil
+	})
+
+	ctx := WithCorrelationID(context.Background(), "run")
+	StructuredBatch[structuredAnswer](ctx, provider, DefaultConfig(), "Extract", batchPrompts(3))
+	for i := range 3 {
+		if id := seen[strconv.Itoa(i)]; id != fmt.Sprintf("run/%d", i) {
+			t.Errorf("Expected item %d to use run/%d, got %q", i, i, id)
+		}
+	}
+
+	// Without one on ctx, the batch gets a new ID shared by its items
+	StructuredBatch[structuredAnswer](context.Background(), provider, DefaultConfig(), "Extract", batchPrompts(2))
+	first, second := seen["0"], seen["1"]
+	prefix, _, _ := strings.Cut(first, "/")
+	if len(prefix) != 16 || first != prefix+"/0" || second != prefix+"/1" {
+		t.Errorf("Expected generated IDs sharing a prefix, got %q and %q", first, second)
+	}
+}
+
+func TestStructuredBatch_ItemErrorsAndRetries(t *testing.T) {
+	var mu sync.Mutex
+	calls := map[string]int{}
+	provider := funcProvider(func(ctx context.Context, req *Request) (*Response, error) {
+		prompt := req.Messages[len(req.Messages)-1].Content
+		mu.Lock()
+		calls[prompt]++
+		attempt := calls[prompt]
+		mu.Unlock()
+
+		switch {
+		case prompt == "flaky" && attempt == 1, prompt == "broken":
+			return nil, &ServerError{APIError{StatusCode: 500, Err: errors.New("boom")}}
+		case prompt == "unauthorised":
+			return nil, &AuthError{APIError{StatusCode: 401, Err: errors.New("bad key")}}
+		}
+		return &Response{ID: prompt, Content: `{"answer":"ok","score":1}`, FinishReason: "stop", Usage: Usage{TotalTokens: 5}}, nil
+	})
+
+	config := DefaultConfig()
+	config.Retry = RetryPolicy{MaxAttempts: 1}
+	prompts := []string{"fine", "flaky", "broken", "unauthorised"}
+	results, usage := StructuredBatch[structuredAnswer](context.Background(), provider, config, "", prompts, WithItemRetries(2))
+
+	tests := []struct {
+		attempts int
+		failed   bool
+	}{
+		{1, false},
+		{2, false},
+		{3, true},
+		{1, true},
+	}
+	for i, tt := range tests {
+		result := results[i]
+		if result.Attempts != tt.attempts || (result.Err != nil) != tt.failed {
+			t.Errorf("%s: expected %d attempts (failed %v), got %+v", prompts[i], tt.attempts, tt.failed, result)
+		}
+	}
+
+	var serverErr *ServerError
+	if !errors.As(results[2].Err, &serverErr) {
+		t.Errorf("Expected ServerError for broken item, got %v", results[2].Err)
+	}
+	if usage.TotalTokens != 10 {
+		t.Errorf("Expected 10 total tokens from the two successes, got %d", usage.TotalTokens)
+	}
+}
+
+func TestStructuredBatch_Cancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	provider := funcProvider(func(ctx context.Context, req *Request) (*Response, error) {
+		t.Error("Expected no requests after cancellation")
+		return nil, nil
+	})
+	results, _ := StructuredBatch[structuredAnswer](ctx, provider, DefaultConfig(), "", batchPrompts(5))
+
+	for _, result := range results {
+		if !errors.Is(result.Err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", result.Err)
+		}
+	}
+}
+
+func TestStructuredBatch_RateLimited(t *testing.T) {
+	var calls atomic.Int32
+	provider := funcProvider(func(ctx context.Context, req *Request) (*Response, error) {
+		calls.Add(1)
+		return &Response{ID: "1", Content: `{"answer":"ok","score":1}`, FinishReason: "stop"}, nil
+	})
+
+	// 2 requests per minute: the third item can't start before the deadline
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	results, _ := StructuredBatch[structuredAnswer](ctx, provider, DefaultConfig(), "", batchPrompts(3), WithRateLimit(2, 0), WithItemRetries(0))
+
+	if calls.Load() != 2 {
+		t.Errorf("Expected 2 requests within the limit, got %d", calls.Load())
+	}
+	failed := 0
+	for _, result := range results {
+		if errors.Is(result.Err, context.DeadlineExceeded) {
+			failed++
+		}
+	}
+	if failed != 1 {
+		t.Errorf("Expected 1 item to be held back by the limiter, got %d", failed)
+	}
+}
@@ -0,0 +1,423 @@
+package lib
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/shared"
+)
+
+// BatchJob builds the input file for an OpenAI Batch API job. Batch jobs are
+// processed offline within 24 hours at a lower price than regular requests.
+type BatchJob struct {
+	config *Config
+	lines  []batchInputLine
+	ids    map[string]bool
+}
+
+// batchInputLine is one request in a batch input file
+type batchInputLine struct {
+	CustomID string                         `json:"custom_id"`
+	Method   string                         `json:"method"`
+	URL      string                         `json:"url"`
+	Body     openai.ChatCompletionNewParams `json:"body"`
+}
+
+// NewBatchJob creates an empty batch job whose requests use config's model settings
+func NewBatchJob(config *Config) *BatchJob {
+	return &BatchJob{config: config, ids: map[string]bool{}}
+}
+
+// Add adds a chat completion for messages. customID identifies the request's
+// output and must be unique within the job.
+func (j *BatchJob) Add(customID string, messages []Message) error {
+	return j.add(customID, newRequest(j.config, messages))
+}
+
+// AddStructured adds a structured completion for messages whose output
+// decodes into a T. customID must be unique within the job.
+func AddStructured[T any](j *BatchJob, customID string, messages []Message) error {
+	schema, err := structuredSchema(j.config, reflect.TypeOf((*T)(nil)).Elem())
+	if err != nil {
+		return err
+	}
+
+	req := newRequest(j.config, messages)
+	req.Schema = schema
+	return j.add(customID, req)
+}
+
+func (j *BatchJob) add(customID string, req *Request) error {
+	if customID == "" {
+		return errors.New("batch request custom ID must not be empty")
+	}
+	if j.ids[customID] {
+		return fmt.Errorf("batch request custom ID %q is already used", customID)
+	}
+
+	j.ids[customID] = true
+	j.lines = append(j.lines, batchInputLine{
+		CustomID: customID,
+		Method:   "POST",
+		URL:      "/v1/chat/completions",
+		Body:     buildOpenAIParams(req),
+	})
+	return nil
+}
+
+// Len returns the number of requests in the job
+func (j *BatchJob) Len() int {
+	return len(j.lines)
+}
+
+// WriteJSONL writes the job's input file, one request per line
+func (j *BatchJob) WriteJSONL(w io.Writer) error {
+	encoder := json.NewEncoder(w)
+	for _, line := range j.lines {
+		if err := encoder.Encode(line); err != nil {
+			return fmt.Errorf("failed to encode batch request %q: %w", line.CustomID, err)
+		}
+	}
+	return nil
+}
+
+// BatchStatus is the state of a submitted batch job
+type BatchStatus struct {
+	ID string
+	// Status is one of "validating", "failed", "in_progress", "finalizing",
+	// "completed", "expired", "cancelling" or "cancelled"
+	Status       string
+	OutputFileID string
+	ErrorFileID  string
+	Total        int
+	Completed    int
+	Failed       int
+	// Errors describes problems with the input file when Status is "failed"
+	Errors []string
+}
+
+// Done reports whether the job has stopped processing
+func (s *BatchStatus) Done() bool {
+	switch s.Status {
+	case "completed", "failed", "expired", "cancelled":
+		return true
+	}
+	return false
+}
+
+// BatchOutput is the result of one request in a batch job
+type BatchOutput struct {
+	CustomID string
+	// Response is set if the request succeeded
+	Response *Response
+	// Err is set if the request failed or did not run before the job ended
+	Err error
+}
+
+// BatchJobResult is a batch output decoded into a T
+type BatchJobResult[T any] struct {
+	Value T
+	Err   error
+	Usage Usage
+}
+
+// BatchClient submits batch jobs and collects their results
+type BatchClient struct {
+	client *openai.Client
+	// PollInterval is how often Wait checks the job's status
+	PollInterval time.Duration
+	// Retry controls how failed API calls are retried
+	Retry RetryPolicy
+	// Logger, if set, logs job submission, status checks and results at
+	// debug level
+	Logger *slog.Logger
+}
+
+// NewBatchClient creates a BatchClient using an existing OpenAI client.
+// Failed API calls are retried according to Retry, not the client's settings.
+func NewBatchClient(client *openai.Client) *BatchClient {
+	return &BatchClient{client: client, PollInterval: 30 * time.Second, Retry: DefaultRetryPolicy()}
+}
+
+// NewBatchClientFromEnv creates a BatchClient from environment variables
+func NewBatchClientFromEnv() (*BatchClient, *Config, error) {
+	client, config, err := newOpenAIClientFromEnv()
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewBatchClient(client), config, nil
+}
+
+// Run submits job, waits for it to finish and returns its outputs keyed by
+// custom ID. Its log lines share the correlation ID from ctx, or a new one.
+func (c *BatchClient) Run(ctx context.Context, job *BatchJob) (map[string]BatchOutput, error) {
+	ctx = ensureCorrelationID(ctx)
+	status, err := c.Submit(ctx, job)
+	if err != nil {
+		return nil, err
+	}
+
+	status, err = c.Wait(ctx, status.ID)
+	if err != nil {
+		return nil, err
+	}
+	if status.Status == "failed" {
+		return nil, fmt.Errorf("batch %s failed: %s", status.ID, strings.Join(status.Errors, "; "))
+	}
+	return c.Results(ctx, status)
+}
+
+// Submit uploads job's input file and starts the batch. The correlation ID
+// from ctx, if any, is saved in the batch's metadata.
+func (c *BatchClient) Submit(ctx context.Context, job *BatchJob) (*BatchStatus, error) {
+	if job.Len() == 0 {
+		return nil, errors.New("batch job has no requests")
+	}
+
+	var input bytes.Buffer
+	if err := job.WriteJSONL(&input); err != nil {
+		return nil, err
+	}
+
+	file, err := withRetry(ctx, c.Retry, func() (*openai.FileObject, error) {
+		file, err := c.client.Files.New(ctx, openai.FileNewParams{
+			File:    openai.File(bytes.NewReader(input.Bytes()), "batch.jsonl", "application/jsonl"),
+			Purpose: openai.FilePurposeBatch,
+		}, noSDKRetries)
+		return file, fromOpenAIError(err)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload batch input: %w", err)
+	}
+
+	params := openai.BatchNewParams{
+		CompletionWindow: openai.BatchNewParamsCompletionWindow24h,
+		Endpoint:         openai.BatchNewParamsEndpointV1ChatCompletions,
+		InputFileID:      file.ID,
+	}
+	if id := CorrelationID(ctx); id != "" {
+		params.Metadata = shared.Metadata{"correlation_id": id}
+	}
+	batch, err := withRetry(ctx, c.Retry, func() (*openai.Batch, error) {
+		batch, err := c.client.Batches.New(ctx, params, noSDKRetries)
+		return batch, fromOpenAIError(err)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create batch: %w", err)
+	}
+
+	status := fromOpenAIBatch(batch)
+	if logger := contextLogger(ctx, c.Logger); logger != nil {
+		logger.DebugContext(ctx, "ai batch submitted", "batch_id", status.ID, "requests", job.Len(), "input_file_id", file.ID)
+	}
+	return status, nil
+}
+
+// Status returns the current state of the batch with the given ID
+func (c *BatchClient) Status(ctx context.Context, id string) (*BatchStatus, error) {
+	batch, err := withRetry(ctx, c.Retry, func() (*openai.Batch, error) {
+		batch, err := c.client.Batches.Get(ctx, id, noSDKRetries)
+		return batch, fromOpenAIError(err)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get batch %s: %w", id, err)
+	}
+
+	status := fromOpenAIBatch(batch)
+	if logger := contextLogger(ctx, c.Logger); logger != nil {
+		logger.DebugContext(ctx, "ai batch status", "batch_id", status.ID, "status", status.Status,
+			"total", status.Total, "completed", status.Completed, "failed", status.Failed)
+	}
+	return status, nil
+}
+
+// Wait polls the batch with the given ID until it is done or ctx ends
+func (c *BatchClient) Wait(ctx context.Context, id string) (*BatchStatus, error) {
+	for {
+		status, err := c.Status(ctx, id)
+		if err != nil {
+			return nil, err
+		}
+		if status.Done() {
+			return status, nil
+		}
+
+		timer := time.NewTimer(c.PollInterval)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return status, ctx.Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// Results downloads the outputs of a finished batch, keyed by custom ID.
+// Requests that failed, or did not run before the batch expired or was
+// cancelled, have Err set.
+func (c *BatchClient) Results(ctx context.Context, status *BatchStatus) (map[string]BatchOutput, error) {
+	outputs := map[string]BatchOutput{}
+	for _, fileID := range []string{status.OutputFileID, status.ErrorFileID} {
+		if fileID == "" {
+			continue
+		}
+		if err := c.download(ctx, fileID, outputs); err != nil {
+			return nil, err
+		}
+	}
+
+	if logger := contextLogger(ctx, c.Logger); logger != nil {
+		failed := 0
+		for _, output := range outputs {
+			if output.Err != nil {
+				failed++
+			}
+		}
+		logger.DebugContext(ctx, "ai batch results", "batch_id", status.ID, "outputs", len(outputs), "failed", failed)
+	}
+	return outputs, nil
+}
+
+// download reads the output file with the given ID into outputs
+func (c *BatchClient) download(ctx context.Context, fileID string, outputs map[string]BatchOutput) error {
+	data, err := withRetry(ctx, c.Retry, func() ([]byte, error) {
+		resp, err := c.client.Files.Content(ctx, fileID, noSDKRetries)
+		if err != nil {
+			return nil, fromOpenAIError(err)
+		}
+		defer resp.Body.Close()
+		return io.ReadAll(resp.Body)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to download batch file %s: %w", fileID, err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	scanner.Buffer(nil, len(data)+1)
+	for scanner.Scan() {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+		output, err := parseBatchOutputLine(scanner.Bytes())
+		if err != nil {
+			return fmt.Errorf("failed to parse batch file %s: %w", fileID, err)
+		}
+		outputs[output.CustomID] = output
+	}
+	return scanner.Err()
+}
+
+// batchOutputLine is one result in a batch output or error file
+type batchOutputLine struct {
+	CustomID string `json:"custom_id"`
+	Response *struct {
+		StatusCode int             `json:"status_code"`
+		Body       json.RawMessage `json:"body"`
+	} `json:"response"`
+	Error *struct {
+		Code    string `json:"code"`
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+// parseBatchOutputLine converts a result line to a BatchOutput
+func parseBatchOutputLine(data []byte) (BatchOutput, error) {
+	var line batchOutputLine
+	if err := json.Unmarshal(data, &line); err != nil {
+		return BatchOutput{}, err
+	}
+
+	output := BatchOutput{CustomID: line.CustomID}
+	switch {
+	case line.Error != nil:
+		output.Err = fmt.Errorf("batch request failed: %s: %s", line.Error.Code, line.Error.Message)
+	case line.Response == nil:
+		output.Err = errors.New("batch request has no response")
+	case line.Response.StatusCode != 200:
+		var body struct {
+			Error struct {
+				Message string `json:"message"`
+			} `json:"error"`
+		}
+		json.Unmarshal(line.Response.Body, &body)
+		output.Err = newStatusError(line.Response.StatusCode, nil, errors.New(body.Error.Message))
+	default:
+		var completion openai.ChatCompletion
+		if err := json.Unmarshal(line.Response.Body, &completion); err != nil {
+			return BatchOutput{}, fmt.Errorf("invalid response for %q: %w", line.CustomID, err)
+		}
+		output.Response, output.Err = fromOpenAICompletion(&Request{Model: completion.Model}, &completion)
+	}
+	return output, nil
+}
+
+// DecodeBatchOutput decodes a structured batch output into a T. Responses
+// that fail validation can't be repaired offline, so they are returned as a
+// *ValidationError with a single attempt.
+func DecodeBatchOutput[T any](output BatchOutput) (T, error) {
+	var result T
+	if output.Err != nil {
+		return result, output.Err
+	}
+
+	resp := output.Response
+	if err := checkResponse(resp.Model, resp); err != nil {
+		return result, err
+	}
+
+	if err := json.Unmarshal([]byte(resp.Content), &result); err != nil {
+		var zero T
+		return zero, fmt.Errorf("failed to parse JSON response: %w (content preview: %.100s...)", err, resp.Content)
+	}
+	if err := validateTarget(&result); err != nil {
+		var zero T
+		return zero, &ValidationError{Attempts: []ValidationAttempt{{Content: resp.Content, Err: err}}}
+	}
+	return result, nil
+}
+
+// DecodeBatchOutputs decodes every structured batch output into a T, keyed
+// by custom ID
+func DecodeBatchOutputs[T any](outputs map[string]BatchOutput) map[string]BatchJobResult[T] {
+	results := make(map[string]BatchJobResult[T], len(outputs))
+	for id, output := range outputs {
+		value, err := DecodeBatchOutput[T](output)
+		result := BatchJobResult[T]{Value: value, Err: err}
+		if output.Response != nil {
+			result.Usage = output.Response.Usage
+		}
+		results[id] = result
+	}
+	return results
+}
+
+// fromOpenAIBatch converts an OpenAI batch to a BatchStatus
+func fromOpenAIBatch(batch *openai.Batch) *BatchStatus {
+	status := &BatchStatus{
+		ID:           batch.ID,
+		Status:       string(batch.Status),
+		OutputFileID: batch.OutputFileID,
+		ErrorFileID:  batch.ErrorFileID,
+		Total:        int(batch.RequestCounts.Total),
+		Completed:    int(batch.RequestCounts.Completed),
+		Failed:       int(batch.RequestCounts.Failed),
+	}
+	for _, e := range batch.Errors.Data {
+		msg := e.Code + ": " + e.Message
+		if e.Line > 0 {
+			msg = fmt.Sprintf("line %d: %s", e.Line, msg)
+		}
+		status.Errors = append(status.Errors, msg)
+	}
+	return status
+}
@@ -0,0 +1,317 @@
+package lib
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+func TestBatchJob_WriteJSONL(t *testing.T) {
+	job := NewBatchJob(DefaultConfig())
+	messages := []Message{{Role: "user", Content: "What is 2+2?"}}
+
+	if err := job.Add("plain", messages); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := AddStructured[structuredAnswer](job, "structured", messages); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := job.Add("plain", messages); err == nil {
+		t.Error("Expected an error for a duplicate custom ID")
+	}
+	if err := job.Add("", messages); err == nil {
+		t.Error("Expected an error for an empty custom ID")
+	}
+	if job.Len() != 2 {
+		t.Errorf("Expected 2 requests, got %d", job.Len())
+	}
+
+	var out strings.Builder
+	if err := job.WriteJSONL(&out); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Expected 2 lines, got %d", len(lines))
+	}
+	for i, id := range []string{"plain", "structured"} {
+		var line struct {
+			CustomID string                 `json:"custom_id"`
+			Method   string                 `json:"method"`
+			URL      string                 `json:"url"`
+			Body     map[string]interface{} `json:"body"`
+		}
+		if err := json.Unmarshal([]byte(lines[i]), &line); err != nil {
+			t.Fatalf("Invalid JSONL line %d: %v", i, err)
+		}
+		if line.CustomID != id || line.Method != "POST" || line.URL != "/v1/chat/completions" {
+			t.Errorf("Unexpected request line %d: %s", i, lines[i])
+		}
+		if line.Body["model"] != "gpt-5-mini" || line.Body["max_completion_tokens"] != float64(1000) {
+			t.Errorf("Expected the config's model settings in line %d, got %v", i, line.Body)
+		}
+		_, hasFormat := line.Body["response_format"]
+		if hasFormat != (id == "structured") {
+			t.Errorf("Expected response_format only on the structured request, got %v for %s", hasFormat, id)
+		}
+	}
+}
+
+func TestAddStructured_UnsupportedModel(t *testing.T) {
+	config := DefaultConfig()
+	config.Model = "gpt-3.5-turbo"
+	job := NewBatchJob(config)
+
+	if err := AddStructured[structuredAnswer](job, "1", nil); err == nil {
+		t.Error("Expected an error for a model without structured outputs")
+	}
+}
+
+// batchServer is a local stand-in for the Files and Batches APIs
+type batchServer struct {
+	mu sync.Mutex
+	// input is the uploaded batch input file
+	input string
+	// polls counts status requests; the batch completes on the second
+	polls int
+	// status is the final batch status
+	status string
+	// metadata is the metadata the batch was created with
+	metadata map[string]string
+	files    map[string]string
+}
+
+func (s *batchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	switch {
+	case r.Method == http.MethodPost && r.URL.Path == "/files":
+		file, _, err := r.FormFile("file")
+		if err != nil || r.FormValue("purpose") != "batch" {
+			http.Error(w, `{"error":{"message":"bad upload"}}`, http.StatusBadRequest)
+			return
+		}
+		data, _ := io.ReadAll(file)
+		s.input = string(data)
+		fmt.Fprint(w, `{"id":"file-in","object":"file","purpose":"batch"}`)
+	case r.Method == http.MethodPost && r.URL.Path == "/batches":
+		var body struct {
+			InputFileID string            `json:"input_file_id"`
+			Endpoint    string            `json:"endpoint"`
+			Metadata    map[string]string `json:"metadata"`
+		}
+		json.NewDecoder(r.Body).Decode(&body)
+		s.metadata = body.Metadata
+		if body.InputFileID != "file-in" || body.Endpoint != "/v1/chat/completions" {
+			http.Error(w, `{"error":{"message":"bad batch"}}`, http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, `{"id":"batch-1","object":"batch","status":"validating"}`)
+	case r.Method == http.MethodGet && r.URL.Path == "/batches/batch-1":
+		s.polls++
+		if s.polls < 2 {
+			fmt.Fprint(w, `{"id":"batch-1","object":"batch","status":"in_progress"}`)
+			return
+		}
+		if s.status == "failed" {
+			fmt.Fprint(w, `{"id":"batch-1","object":"batch","status":"failed","errors":{"data":[{"code":"invalid_request","line":1,"message":"bad model"}]}}`)
+			return
+		}
+		fmt.Fprintf(w, `{"id":"batch-1","object":"batch","status":%q,"output_file_id":"file-out","error_file_id":"file-err","request_counts":{"total":4,"completed":3,"failed":1}}`, s.status)
+	case r.Method == http.MethodGet && strings.HasPrefix(r.URL.Path, "/files/") && strings.HasSuffix(r.URL.Path, "/content"):
+		id := strings.TrimSuffix(strings.TrimPrefix(r.URL.Path, "/files/"), "/content")
+		fmt.Fprint(w, s.files[id])
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// batchCompletion is an output file line holding a chat completion with content
+func batchCompletion(customID, content, finishReason string) string {
+	completion := map[string]interface{}{
+		"id":      "chatcmpl-" + customID,
+		"object":  "chat.completion",
+		"model":   "gpt-5-mini",
+		"choices": []interface{}{map[string]interface{}{"index": 0, "finish_reason": finishReason, "message": map[string]interface{}{"role": "assistant", "content": content}}},
+		"usage":   map[string]interface{}{"prompt_tokens": 10, "completion_tokens": 5, "total_tokens": 15},
+	}
+	line, _ := json.Marshal(map[string]interface{}{
+		"custom_id": customID,
+		"response":  map[string]interface{}{"status_code": 200, "body": completion},
+	})
+	return string(line)
+}
+
+func newTestBatchClient(server *httptest.Server) *BatchClient {
+	client := openai.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+	batch := NewBatchClient(&client)
+	batch.PollInterval = time.Millisecond
+	batch.Retry = RetryPolicy{MaxAttempts: 1}
+	return batch
+}
+
+func TestBatchClient_Run(t *testing.T) {
+	stub := &batchServer{
+		status: "completed",
+		files: map[string]string{
+			"file-out": strings.Join([]string{
+				batchCompletion("ok", `{"answer":"4","score":9}`, "stop"),
+				batchCompletion("invalid", `{"answer":""}`, "stop"),
+				batchCompletion("truncated", `{"answer":`, "length"),
+			}, "\n") + "\n",
+			"file-err": `{"custom_id":"rejected","response":{"status_code":429,"body":{"error":{"message":"slow down"}}}}` + "\n" +
+				`{"custom_id":"expired","response":null,"error":{"code":"batch_expired","message":"not run in time"}}`,
+		},
+	}
+	server := httptest.NewServer(stub)
+	defer server.Close()
+
+	job := NewBatchJob(DefaultConfig())
+	for _, id := range []string{"ok", "invalid", "truncated", "rejected", "expired"} {
+		if err := AddStructured[validatedAnswer](job, id, []Message{{Role: "user", Content: id}}); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+
+	client := newTestBatchClient(server)
+	logger, buf := newTestLogger(slog.LevelDebug)
+	client.Logger = logger
+	outputs, err := client.Run(WithCorrelationID(context.Background(), "job-1"), job)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if stub.metadata["correlation_id"] != "job-1" {
+		t.Errorf("Expected the correlation ID in the batch metadata, got %v", stub.metadata)
+	}
+	var messages []string
+	for _, line := range logLines(t, buf) {
+		if line["correlation_id"] != "job-1" || line["batch_id"] != "batch-1" {
+			t.Errorf("Expected the job's correlation and batch IDs, got %v", line)
+		}
+		messages = append(messages, line["msg"].(string))
+	}
+	expected := "ai batch submitted,ai batch status,ai batch status,ai batch results"
+	if got := strings.Join(messages, ","); got != expected {
+		t.Errorf("Expected log lines %q, got %q", expected, got)
+	}
+
+	scanner := bufio.NewScanner(strings.NewReader(stub.input))
+	uploaded := 0
+	for scanner.Scan() {
+		uploaded++
+	}
+	if uploaded != 5 {
+		t.Errorf("Expected 5 uploaded requests, got %d", uploaded)
+	}
+	if stub.polls != 2 {
+		t.Errorf("Expected 2 status polls, got %d", stub.polls)
+	}
+
+	results := DecodeBatchOutputs[validatedAnswer](outputs)
+	if len(results) != 5 {
+		t.Fatalf("Expected 5 results, got %d", len(results))
+	}
+
+	if r := results["ok"]; r.Err != nil || r.Value.Answer != "4" || r.Usage.TotalTokens != 15 {
+		t.Errorf("Unexpected result for ok: %+v", r)
+	}
+
+	var validationErr *ValidationError
+	if !errors.As(results["invalid"].Err, &validationErr) || len(validationErr.Attempts) != 1 {
+		t.Errorf("Expected a ValidationError with one attempt, got %v", results["invalid"].Err)
+	}
+	if !errors.Is(results["truncated"].Err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", results["truncated"].Err)
+	}
+
+	var rateLimitErr *RateLimitError
+	if !errors.As(results["rejected"].Err, &rateLimitErr) {
+		t.Errorf("Expected RateLimitError, got %v", results["rejected"].Err)
+	}
+	if err := results["expired"].Err; err == nil || !strings.Contains(err.Error(), "batch_expired") {
+		t.Errorf("Expected a batch_expired error, got %v", err)
+	}
+}
+
+func TestBatchClient_RunFailed(t *testing.T) {
+	server := httptest.NewServer(&batchServer{status: "failed"})
+	defer server.Close()
+
+	job := NewBatchJob(DefaultConfig())
+	job.Add("1", []Message{{Role: "user", Content: "hi"}})
+
+	_, err := newTestBatchClient(server).Run(context.Background(), job)
+	if err == nil || !strings.Contains(err.Error(), "line 1: invalid_request: bad model") {
+		t.Errorf("Expected the batch's validation errors, got %v", err)
+	}
+}
+
+func TestBatchClient_SubmitEmpty(t *testing.T) {
+	client := openai.NewClient(option.WithAPIKey("test-key"))
+	if _, err := NewBatchClient(&client).Submit(context.Background(), NewBatchJob(DefaultConfig())); err == nil {
+		t.Error("Expected an error for an empty job")
+	}
+}
+
+func TestBatchClient_IgnoresSDKRetries(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After-Ms", "1")
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, `{"error":{"message":"down"}}`)
+	}))
+	defer server.Close()
+
+	// The client keeps the SDK's default of 2 retries
+	client := openai.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL))
+	batch := NewBatchClient(&client)
+	batch.Retry = RetryPolicy{MaxAttempts: 1}
+
+	if _, err := batch.Status(context.Background(), "batch-1"); err == nil {
+		t.Error("Expected an error")
+	}
+	if calls != 1 {
+		t.Errorf("Expected a single attempt, got %d calls", calls)
+	}
+}
+
+func TestBatchStatus_Done(t *testing.T) {
+	tests := []struct {
+		status string
+		done   bool
+	}{
+		{"validating", false},
+		{"in_progress", false},
+		{"finalizing", false},
+		{"cancelling", false},
+		{"completed", true},
+		{"failed", true},
+		{"expired", true},
+		{"cancelled", true},
+	}
+
+	for _, tt := range tests {
+		if got := (&BatchStatus{Status: tt.status}).Done(); got != tt.done {
+			t.Errorf("Expected Done() %v for %s, got %v", tt.done, tt.status, got)
+		}
+	}
+}
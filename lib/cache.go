@@ -0,0 +1,220 @@
+package lib
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Cache stores responses by request fingerprint. Set Config.Cache to answer
+// repeated identical requests without calling the provider. Implementations
+// must be safe for concurrent use; a failure to read or write should be
+// treated as a miss.
+type Cache interface {
+	Get(key string) (*Response, bool)
+	Set(key string, resp *Response)
+}
+
+// CacheMode controls how a call uses Config.Cache
+type CacheMode int
+
+const (
+	// CacheDefault answers from the cache when possible and stores new responses
+	CacheDefault CacheMode = iota
+	// CacheRefresh skips the lookup but stores the new response
+	CacheRefresh
+	// CacheBypass neither reads nor writes the cache
+	CacheBypass
+)
+
+type cacheModeKey struct{}
+
+// WithCacheMode returns a context whose calls use the cache according to mode
+func WithCacheMode(ctx context.Context, mode CacheMode) context.Context {
+	return context.WithValue(ctx, cacheModeKey{}, mode)
+}
+
+// cacheLookup returns the cache key for req, and the cached response if
+// there is one. The key is empty if the call doesn't use the cache.
+func cacheLookup(ctx context.Context, config *Config, req *Request) (string, *Response) {
+	mode, _ := ctx.Value(cacheModeKey{}).(CacheMode)
+	if config.Cache == nil || mode == CacheBypass {
+		return "", nil
+	}
+
+	key := requestFingerprint(req)
+	if mode == CacheRefresh {
+		return key, nil
+	}
+	resp, ok := config.Cache.Get(key)
+	if !ok {
+		return key, nil
+	}
+
+	// A hit costs nothing, but is counted
+	hit := copyResponse(resp)
+	hit.Usage = Usage{CacheHits: 1}
+	hit.Cached = true
+	return key, hit
+}
+
+// cacheStore saves resp under key if it is a complete answer worth reusing
+func cacheStore(config *Config, key string, resp *Response) {
+	if key == "" || resp.Refusal != "" {
+		return
+	}
+	if resp.FinishReason == "stop" || resp.FinishReason == "tool_calls" {
+		config.Cache.Set(key, copyResponse(resp))
+	}
+}
+
+// requestFingerprint identifies a request by everything that affects the
+// response: model, messages, schema, tools and parameters
+func requestFingerprint(req *Request) string {
+	data, _ := json.Marshal(struct {
+		Version int
+		*Request
+	}{1, req})
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// copyResponse copies resp so cached responses can't be changed by callers
+func copyResponse(resp *Response) *Response {
+	c := *resp
+	c.ToolCalls = append([]ToolCall(nil), resp.ToolCalls...)
+	return &c
+}
+
+// MemoryCache is an in-memory Cache that evicts the least recently used
+// response once it holds maxEntries
+type MemoryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List
+	entries    map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key  string
+	resp *Response
+}
+
+// NewMemoryCache creates a MemoryCache holding up to maxEntries responses
+// (at least 1)
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	return &MemoryCache{maxEntries: max(maxEntries, 1), order: list.New(), entries: map[string]*list.Element{}}
+}
+
+// Get implements Cache
+func (c *MemoryCache) Get(key string) (*Response, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(elem)
+	return copyResponse(elem.Value.(*memoryEntry).resp), true
+}
+
+// Set implements Cache
+func (c *MemoryCache) Set(key string, resp *Response) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[key]; ok {
+		elem.Value.(*memoryEntry).resp = copyResponse(resp)
+		c.order.MoveToFront(elem)
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&memoryEntry{key: key, resp: copyResponse(resp)})
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryEntry).key)
+	}
+}
+
+// Len returns the number of cached responses
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
+
+// DiskCache is a Cache storing one JSON file per response in a directory,
+// so responses survive between runs. Entries older than the TTL are
+// ignored and removed when read.
+type DiskCache struct {
+	dir string
+	ttl time.Duration
+	now func() time.Time
+}
+
+type diskEntry struct {
+	Created  time.Time `json:"created"`
+	Response *Response `json:"response"`
+}
+
+// NewDiskCache creates a DiskCache in dir, creating the directory if needed.
+// A ttl of zero keeps entries forever.
+func NewDiskCache(dir string, ttl time.Duration) (*DiskCache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	return &DiskCache{dir: dir, ttl: ttl, now: time.Now}, nil
+}
+
+// Get implements Cache
+func (c *DiskCache) Get(key string) (*Response, bool) {
+	path := c.path(key)
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false
+	}
+
+	var entry diskEntry
+	if err := json.Unmarshal(data, &entry); err != nil || entry.Response == nil {
+		return nil, false
+	}
+	if c.ttl > 0 && c.now().Sub(entry.Created) > c.ttl {
+		os.Remove(path)
+		return nil, false
+	}
+	return entry.Response, true
+}
+
+// Set implements Cache
+func (c *DiskCache) Set(key string, resp *Response) {
+	data, err := json.Marshal(diskEntry{Created: c.now(), Response: resp})
+	if err != nil {
+		return
+	}
+
+	// Write to a temporary file first so readers never see a partial entry
+	tmp, err := os.CreateTemp(c.dir, key+".*.tmp")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil || os.Rename(tmp.Name(), c.path(key)) != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+func (c *DiskCache) path(key string) string {
+	return filepath.Join(c.dir, key+".json")
+}
@@ -0,0 +1,206 @@
+package lib
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRequestFingerprint(t *testing.T) {
+	base := func() *Request {
+		return newRequest(DefaultConfig(), []Message{{Role: "system", Content: "sys"}, {Role: "user", Content: "hi"}})
+	}
+	temperature := 0.5
+
+	tests := []struct {
+		name   string
+		modify func(req *Request)
+		same   bool
+	}{
+		{"identical", func(req *Request) {}, true},
+		{"model", func(req *Request) { req.Model = "gpt-4o" }, false},
+		{"message", func(req *Request) { req.Messages[1].Content = "hello" }, false},
+		{"attachment", func(req *Request) { req.Messages[1].Parts = []ContentPart{ImageURL("https://example.com/a.png")} }, false},
+		{"max tokens", func(req *Request) { req.MaxTokens = 5 }, false},
+		{"temperature", func(req *Request) { req.Temperature = &temperature }, false},
+		{"schema", func(req *Request) { req.Schema = &ResponseSchema{Name: "answer"} }, false},
+		{"tools", func(req *Request) { req.Tools = []ToolDefinition{{Name: "add"}} }, false},
+	}
+
+	key := requestFingerprint(base())
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := base()
+			tt.modify(req)
+			if got := requestFingerprint(req) == key; got != tt.same {
+				t.Errorf("Expected same fingerprint %v, got %v", tt.same, got)
+			}
+		})
+	}
+}
+
+func TestComplete_Cache(t *testing.T) {
+	provider := &fakeProvider{responses: []*Response{
+		{ID: "1", Content: "first", FinishReason: "stop", Usage: Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15}},
+		{ID: "2", Content: "second", FinishReason: "stop", Usage: Usage{TotalTokens: 15}},
+		{ID: "3", Content: "third", FinishReason: "stop", Usage: Usage{TotalTokens: 15}},
+	}}
+	config := DefaultConfig()
+	config.Cache = NewMemoryCache(10)
+
+	meter := NewUsageMeter()
+	ctx := WithUsageMeter(context.Background(), meter)
+	query := func(ctx context.Context) *Response {
+		resp, err := complete(ctx, provider, config, newRequest(config, []Message{{Role: "user", Content: "hi"}}))
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		return resp
+	}
+
+	tests := []struct {
+		name     string
+		ctx      context.Context
+		expected string
+		cached   bool
+	}{
+		{"miss", ctx, "first", false},
+		{"hit", ctx, "first", true},
+		{"refresh", WithCacheMode(ctx, CacheRefresh), "second", false},
+		{"hit after refresh", ctx, "second", true},
+		{"bypass", WithCacheMode(ctx, CacheBypass), "third", false},
+		{"bypass doesn't store", ctx, "second", true},
+	}
+	for _, tt := range tests {
+		resp := query(tt.ctx)
+		if resp.Content != tt.expected || resp.Cached != tt.cached {
+			t.Errorf("%s: expected %q (cached %v), got %q (cached %v)", tt.name, tt.expected, tt.cached, resp.Content, resp.Cached)
+		}
+		if tt.cached && resp.Usage != (Usage{CacheHits: 1}) {
+			t.Errorf("%s: expected a zero-token cache hit, got %+v", tt.name, resp.Usage)
+		}
+	}
+
+	if len(provider.requests) != 3 {
+		t.Errorf("Expected 3 provider calls, got %d", len(provider.requests))
+	}
+	total := meter.Total()
+	if total.TotalTokens != 45 || total.CacheHits != 3 {
+		t.Errorf("Expected 45 tokens and 3 cache hits, got %+v", total)
+	}
+	if cost, _ := meter.Cost(PriceTable{config.Model: {Input: 1, Output: 1}}); cost != 15.0/1_000_000 {
+		t.Errorf("Expected cache hits to cost nothing, got %v", cost)
+	}
+}
+
+func TestComplete_CacheSkipsIncompleteResponses(t *testing.T) {
+	tests := []struct {
+		name string
+		resp *Response
+	}{
+		{"truncated", &Response{Content: "par", FinishReason: "length"}},
+		{"refusal", &Response{Refusal: "no", FinishReason: "stop"}},
+		{"content filter", &Response{FinishReason: "content_filter"}},
+	}
+
+	for _, tt := range tests {
+		cache := NewMemoryCache(10)
+		config := DefaultConfig()
+		config.Cache = cache
+		provider := &fakeProvider{responses: []*Response{tt.resp}}
+
+		complete(context.Background(), provider, config, newRequest(config, []Message{{Role: "user", Content: "hi"}}))
+		if cache.Len() != 0 {
+			t.Errorf("%s: expected the response not to be cached", tt.name)
+		}
+	}
+}
+
+func TestCompleteStream_Cache(t *testing.T) {
+	config := DefaultConfig()
+	config.Cache = NewMemoryCache(10)
+	provider := &fakeProvider{}
+	req := newRequest(config, []Message{{Role: "user", Content: "hi"}})
+
+	var deltas []string
+	for i := 0; i < 2; i++ {
+		resp, err := completeStream(context.Background(), provider, config, req, func(delta string) {
+			deltas = append(deltas, delta)
+		})
+		if err != nil || resp.Content != "ok" || resp.Cached != (i == 1) {
+			t.Errorf("Unexpected response %d: %+v, %v", i, resp, err)
+		}
+	}
+
+	if len(provider.requests) != 1 || len(deltas) != 2 || deltas[1] != "ok" {
+		t.Errorf("Expected one request and the cached content as a delta, got %d requests and %q", len(provider.requests), deltas)
+	}
+}
+
+func TestMemoryCache_Eviction(t *testing.T) {
+	cache := NewMemoryCache(2)
+	cache.Set("a", &Response{Content: "a"})
+	cache.Set("b", &Response{Content: "b"})
+	cache.Get("a") // a is now the most recently used
+	cache.Set("c", &Response{Content: "c"})
+
+	tests := []struct {
+		key   string
+		found bool
+	}{
+		{"a", true},
+		{"b", false},
+		{"c", true},
+	}
+	for _, tt := range tests {
+		if _, ok := cache.Get(tt.key); ok != tt.found {
+			t.Errorf("Expected %s found %v, got %v", tt.key, tt.found, ok)
+		}
+	}
+
+	// Callers can't change cached responses
+	resp, _ := cache.Get("a")
+	resp.Content = "changed"
+	if resp, _ := cache.Get("a"); resp.Content != "a" {
+		t.Errorf("Expected the cached response to be unchanged, got %q", resp.Content)
+	}
+}
+
+func TestDiskCache(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "cache")
+	cache, err := NewDiskCache(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+	cache.now = func() time.Time { return now }
+
+	cache.Set("key", &Response{ID: "1", Content: "hello", FinishReason: "stop", ToolCalls: []ToolCall{{ID: "c", Name: "t"}}, Usage: Usage{TotalTokens: 7}})
+
+	// A new instance reads the same directory
+	reopened, _ := NewDiskCache(dir, time.Hour)
+	reopened.now = cache.now
+	resp, ok := reopened.Get("key")
+	if !ok || resp.Content != "hello" || resp.Usage.TotalTokens != 7 || len(resp.ToolCalls) != 1 {
+		t.Fatalf("Expected the stored response, got %+v, %v", resp, ok)
+	}
+
+	if _, ok := cache.Get("missing"); ok {
+		t.Error("Expected a miss for an unknown key")
+	}
+
+	os.WriteFile(filepath.Join(dir, "corrupt.json"), []byte("{"), 0o644)
+	if _, ok := cache.Get("corrupt"); ok {
+		t.Error("Expected a corrupt entry to be a miss")
+	}
+
+	now = now.Add(2 * time.Hour)
+	if _, ok := cache.Get("key"); ok {
+		t.Error("Expected an expired entry to be a miss")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "key.json")); !os.IsNotExist(err) {
+		t.Errorf("Expected the expired entry to be removed, got %v", err)
+	}
+}
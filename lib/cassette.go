@@ -0,0 +1,252 @@
+package lib
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// CassetteMode selects whether a Recorder records or replays
+type CassetteMode int
+
+const (
+	// CassetteReplay serves responses from the cassette without network access
+	CassetteReplay CassetteMode = iota
+	// CassetteRecord sends requests upstream and records them to the cassette
+	CassetteRecord
+)
+
+// Cassette is a recorded sequence of HTTP interactions
+type Cassette struct {
+	Interactions []Interaction `json:"interactions"`
+}
+
+// Interaction is one recorded request and its response
+type Interaction struct {
+	Request  RecordedRequest  `json:"request"`
+	Response RecordedResponse `json:"response"`
+}
+
+// RecordedRequest is the recorded part of an HTTP request
+type RecordedRequest struct {
+	Method string      `json:"method"`
+	URL    string      `json:"url"`
+	Header http.Header `json:"header,omitempty"`
+	Body   string      `json:"body,omitempty"`
+}
+
+// RecordedResponse is the recorded part of an HTTP response
+type RecordedResponse struct {
+	StatusCode int         `json:"status_code"`
+	Header     http.Header `json:"header,omitempty"`
+	Body       string      `json:"body,omitempty"`
+}
+
+// redactedHeaders are replaced with "REDACTED" before a cassette is saved
+var redactedHeaders = []string{"Authorization", "Api-Key", "Openai-Organization", "Openai-Project", "Cookie", "Set-Cookie"}
+
+// ErrNoInteraction is returned when replaying a request the cassette has no
+// unused recording for
+var ErrNoInteraction = errors.New("no recorded interaction matches the request")
+
+// Recorder is an http.RoundTripper that records HTTP interactions to a
+// cassette file, or replays them offline. Replayed requests match a
+// recording by method, path, query and, for JSON bodies, the body's content
+// regardless of formatting. Each recording is replayed once, in order, so
+// identical requests can get different responses. It is safe for
+// concurrent use.
+type Recorder struct {
+	path string
+	mode CassetteMode
+	next http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+	used     []bool
+}
+
+// NewRecorder creates a Recorder for the cassette file at path. In replay
+// mode the file must exist; in record mode requests are sent with next (or
+// http.DefaultTransport if nil) and Save writes the file.
+func NewRecorder(path string, mode CassetteMode, next http.RoundTripper) (*Recorder, error) {
+	if next == nil {
+		next = http.DefaultTransport
+	}
+	r := &Recorder{path: path, mode: mode, next: next}
+	if mode == CassetteRecord {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cassette: %w", err)
+	}
+	if err := json.Unmarshal(data, &r.cassette); err != nil {
+		return nil, fmt.Errorf("failed to parse cassette %s: %w", path, err)
+	}
+	r.used = make([]bool, len(r.cassette.Interactions))
+	return r, nil
+}
+
+// Client returns an http.Client using the Recorder as its transport
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// RoundTrip implements http.RoundTripper
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %w", err)
+		}
+	}
+
+	recorded := RecordedRequest{Method: req.Method, URL: req.URL.String(), Header: redactHeader(req.Header), Body: string(body)}
+	if r.mode == CassetteReplay {
+		return r.replay(req, recorded)
+	}
+
+	upstream := req.Clone(req.Context())
+	upstream.Body = io.NopCloser(bytes.NewReader(body))
+	upstream.ContentLength = int64(len(body))
+	resp, err := r.next.RoundTrip(upstream)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{
+		Request:  recorded,
+		Response: RecordedResponse{StatusCode: resp.StatusCode, Header: redactHeader(resp.Header), Body: string(respBody)},
+	})
+	r.mu.Unlock()
+
+	resp.Body = io.NopCloser(bytes.NewReader(respBody))
+	return resp, nil
+}
+
+// replay returns the first unused recording matching req
+func (r *Recorder) replay(req *http.Request, recorded RecordedRequest) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i, interaction := range r.cassette.Interactions {
+		if r.used[i] || !requestsMatch(interaction.Request, recorded) {
+			continue
+		}
+		r.used[i] = true
+
+		resp := interaction.Response
+		return &http.Response{
+			Status:        fmt.Sprintf("%d %s", resp.StatusCode, http.StatusText(resp.StatusCode)),
+			StatusCode:    resp.StatusCode,
+			Proto:         "HTTP/1.1",
+			ProtoMajor:    1,
+			ProtoMinor:    1,
+			Header:        resp.Header.Clone(),
+			Body:          io.NopCloser(strings.NewReader(resp.Body)),
+			ContentLength: int64(len(resp.Body)),
+			Request:       req,
+		}, nil
+	}
+	return nil, fmt.Errorf("%w: %s %s in %s", ErrNoInteraction, req.Method, req.URL.Path, r.path)
+}
+
+// Save writes the recorded interactions to the cassette file. It does
+// nothing in replay mode.
+func (r *Recorder) Save() error {
+	if r.mode != CassetteRecord {
+		return nil
+	}
+
+	r.mu.Lock()
+	data, err := json.MarshalIndent(r.cassette, "", "  ")
+	r.mu.Unlock()
+	if err != nil {
+		return fmt.Errorf("failed to encode cassette: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o755); err != nil {
+		return fmt.Errorf("failed to create cassette directory: %w", err)
+	}
+	if err := os.WriteFile(r.path, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to write cassette: %w", err)
+	}
+	return nil
+}
+
+// Unused returns the recorded interactions that have not been replayed
+func (r *Recorder) Unused() []Interaction {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var unused []Interaction
+	for i, interaction := range r.cassette.Interactions {
+		if !r.used[i] {
+			unused = append(unused, interaction)
+		}
+	}
+	return unused
+}
+
+// redactHeader copies header with credentials replaced
+func redactHeader(header http.Header) http.Header {
+	header = header.Clone()
+	for _, name := range redactedHeaders {
+		if header.Get(name) != "" {
+			header.Set(name, "REDACTED")
+		}
+	}
+	return header
+}
+
+// requestsMatch reports whether a replayed request matches a recording
+func requestsMatch(recorded, req RecordedRequest) bool {
+	if recorded.Method != req.Method || !sameEndpoint(recorded.URL, req.URL) {
+		return false
+	}
+	if !strings.Contains(req.Header.Get("Content-Type"), "json") {
+		// Multipart bodies contain random boundaries
+		return true
+	}
+	return canonicalJSON(recorded.Body) == canonicalJSON(req.Body)
+}
+
+// sameEndpoint compares the path and query of two URLs, ignoring the host
+func sameEndpoint(a, b string) bool {
+	ua, errA := url.Parse(a)
+	ub, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return ua.RequestURI() == ub.RequestURI()
+}
+
+// canonicalJSON re-encodes body with sorted keys and no whitespace, so
+// formatting differences don't prevent a match
+func canonicalJSON(body string) string {
+	var v interface{}
+	if err := json.Unmarshal([]byte(body), &v); err != nil {
+		return body
+	}
+	data, _ := json.Marshal(v)
+	return string(data)
+}
@@ -0,0 +1,154 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRecorder_RecordAndReplay(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Set-Cookie", "session=secret")
+		fmt.Fprintf(w, `{"call":%d,"echo":%s}`, calls, body)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "cassettes", "test.json")
+	recorder, err := NewRecorder(path, CassetteRecord, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	post := func(client *http.Client, url, body string) (string, error) {
+		req, _ := http.NewRequest(http.MethodPost, url, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "Bearer sk-secret")
+		resp, err := client.Do(req)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		data, _ := io.ReadAll(resp.Body)
+		return string(data), nil
+	}
+
+	// The same request twice records two interactions
+	for _, body := range []string{`{"a":1,"b":2}`, `{"a":1,"b":2}`, `{"a":3}`} {
+		if _, err := post(recorder.Client(), server.URL+"/v1/chat", body); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+	}
+	if err := recorder.Save(); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	saved, _ := os.ReadFile(path)
+	if strings.Contains(string(saved), "sk-secret") || strings.Contains(string(saved), "session=secret") {
+		t.Error("Expected credentials to be redacted from the cassette")
+	}
+
+	replayer, err := NewRecorder(path, CassetteReplay, nil)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	// Formatting and key order don't matter, nor does the host
+	tests := []struct {
+		body     string
+		expected string
+	}{
+		{`{"a": 3}`, `{"call":3,"echo":{"a":3}}`},
+		{`{"b":2, "a":1}`, `{"call":1,"echo":{"a":1,"b":2}}`},
+		{`{"a":1,"b":2}`, `{"call":2,"echo":{"a":1,"b":2}}`},
+	}
+	for _, tt := range tests {
+		got, err := post(replayer.Client(), "http://offline.invalid/v1/chat", tt.body)
+		if err != nil {
+			t.Fatalf("Unexpected error replaying %s: %v", tt.body, err)
+		}
+		if got != tt.expected {
+			t.Errorf("Expected %s, got %s", tt.expected, got)
+		}
+	}
+
+	if calls != 3 {
+		t.Errorf("Expected replay to make no requests, got %d calls", calls)
+	}
+	if unused := replayer.Unused(); len(unused) != 0 {
+		t.Errorf("Expected every interaction to be used, got %d unused", len(unused))
+	}
+
+	// Each recording is replayed once
+	_, err = post(replayer.Client(), "http://offline.invalid/v1/chat", `{"a":3}`)
+	if !errors.Is(err, ErrNoInteraction) {
+		t.Errorf("Expected ErrNoInteraction, got %v", err)
+	}
+}
+
+func TestRecorder_MissingCassette(t *testing.T) {
+	_, err := NewRecorder(filepath.Join(t.TempDir(), "missing.json"), CassetteReplay, nil)
+	if err == nil {
+		t.Error("Expected an error for a missing cassette in replay mode")
+	}
+}
+
+func TestRequestsMatch(t *testing.T) {
+	json := http.Header{"Content-Type": {"application/json"}}
+	multipart := http.Header{"Content-Type": {"multipart/form-data; boundary=abc"}}
+
+	tests := []struct {
+		name     string
+		recorded RecordedRequest
+		req      RecordedRequest
+		expected bool
+	}{
+		{
+			name:     "same JSON, different host",
+			recorded: RecordedRequest{Method: "POST", URL: "https://api.openai.com/v1/chat/completions", Body: `{"model":"gpt-4o"}`},
+			req:      RecordedRequest{Method: "POST", URL: "http://127.0.0.1:1234/v1/chat/completions", Header: json, Body: `{ "model": "gpt-4o" }`},
+			expected: true,
+		},
+		{
+			name:     "different JSON",
+			recorded: RecordedRequest{Method: "POST", URL: "/v1/chat/completions", Body: `{"model":"gpt-4o"}`},
+			req:      RecordedRequest{Method: "POST", URL: "/v1/chat/completions", Header: json, Body: `{"model":"gpt-5"}`},
+			expected: false,
+		},
+		{
+			name:     "different method",
+			recorded: RecordedRequest{Method: "GET", URL: "/v1/batches/1"},
+			req:      RecordedRequest{Method: "POST", URL: "/v1/batches/1"},
+			expected: false,
+		},
+		{
+			name:     "different query",
+			recorded: RecordedRequest{Method: "GET", URL: "/v1/files?purpose=batch"},
+			req:      RecordedRequest{Method: "GET", URL: "/v1/files?purpose=vision"},
+			expected: false,
+		},
+		{
+			name:     "multipart ignores body",
+			recorded: RecordedRequest{Method: "POST", URL: "/v1/files", Body: "--abc"},
+			req:      RecordedRequest{Method: "POST", URL: "/v1/files", Header: multipart, Body: "--xyz"},
+			expected: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := requestsMatch(tt.recorded, tt.req); got != tt.expected {
+				t.Errorf("Expected %v, got %v", tt.expected, got)
+			}
+		})
+	}
+}
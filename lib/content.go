@@ -0,0 +1,93 @@
+package lib
+
+import (
+	"encoding/base64"
+	"fmt"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+// PartType identifies the kind of a ContentPart
+type PartType string
+
+const (
+	PartText  PartType = "text"
+	PartImage PartType = "image"
+	PartFile  PartType = "file"
+)
+
+// ContentPart is a piece of a multimodal user message
+type ContentPart struct {
+	Type PartType `json:"type"`
+	Text string   `json:"text,omitempty"`
+	// URL is an http(s) URL or a base64 data URL for image and file parts
+	URL string `json:"url,omitempty"`
+	// Filename names file parts
+	Filename string `json:"filename,omitempty"`
+	// Detail is the image detail level: "low", "high" or "auto"
+	Detail string `json:"detail,omitempty"`
+}
+
+// TextPart creates a text content part
+func TextPart(text string) ContentPart {
+	return ContentPart{Type: PartText, Text: text}
+}
+
+// ImageURL creates an image part from an http(s) or data URL
+func ImageURL(url string) ContentPart {
+	return ContentPart{Type: PartImage, URL: url}
+}
+
+// ImageData creates an image part from raw bytes, sent base64-encoded. If
+// mediaType is empty it is detected from the data.
+func ImageData(data []byte, mediaType string) ContentPart {
+	if mediaType == "" {
+		mediaType = http.DetectContentType(data)
+	}
+	return ContentPart{Type: PartImage, URL: dataURL(mediaType, data)}
+}
+
+// FileData creates a file part, such as a PDF, from raw bytes sent
+// base64-encoded. If mediaType is empty it is detected from the data.
+func FileData(filename string, data []byte, mediaType string) ContentPart {
+	if mediaType == "" {
+		mediaType = http.DetectContentType(data)
+	}
+	return ContentPart{Type: PartFile, URL: dataURL(mediaType, data), Filename: filename}
+}
+
+// AttachFile reads the file at path and creates the matching part: an image
+// part for images, a text part holding the contents for text files, and a
+// file part for anything else
+func AttachFile(path string) (ContentPart, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return ContentPart{}, fmt.Errorf("failed to read attachment: %w", err)
+	}
+
+	name := filepath.Base(path)
+	mediaType := mime.TypeByExtension(filepath.Ext(path))
+	if mediaType == "" {
+		mediaType = http.DetectContentType(data)
+	}
+
+	switch {
+	case strings.HasPrefix(mediaType, "image/"):
+		return ImageData(data, mediaType), nil
+	case strings.HasPrefix(mediaType, "text/") || mediaType == "application/json" || (utf8.Valid(data) && !strings.Contains(mediaType, "pdf")):
+		return TextPart(fmt.Sprintf("File: %s\n```\n%s\n```", name, data)), nil
+	default:
+		return FileData(name, data, mediaType), nil
+	}
+}
+
+// dataURL encodes data as a base64 data URL
+func dataURL(mediaType string, data []byte) string {
+	// Drop parameters such as "; charset=utf-8" from detected types
+	mediaType, _, _ = strings.Cut(mediaType, ";")
+	return "data:" + mediaType + ";base64," + base64.StdEncoding.EncodeToString(data)
+}
@@ -0,0 +1,161 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR")
+
+func TestImageData(t *testing.T) {
+	part := ImageData(pngHeader, "")
+	if part.Type != PartImage || !strings.HasPrefix(part.URL, "data:image/png;base64,") {
+		t.Errorf("Unexpected image part: %+v", part)
+	}
+
+	part = ImageData([]byte("raw"), "image/jpeg")
+	if part.URL != "data:image/jpeg;base64,cmF3" {
+		t.Errorf("Expected explicit media type to be used, got %s", part.URL)
+	}
+}
+
+func TestAttachFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name string, data []byte) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, data, 0o644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+		return path
+	}
+
+	tests := []struct {
+		name     string
+		path     string
+		wantType PartType
+		check    func(ContentPart) bool
+	}{
+		{"image", write("screenshot.png", pngHeader), PartImage, func(p ContentPart) bool {
+			return strings.HasPrefix(p.URL, "data:image/png;base64,")
+		}},
+		{"text", write("error.log", []byte("panic: oops")), PartText, func(p ContentPart) bool {
+			return strings.Contains(p.Text, "File: error.log") && strings.Contains(p.Text, "panic: oops")
+		}},
+		{"pdf", write("report.pdf", []byte("%PDF-1.4\n\xff\xfe")), PartFile, func(p ContentPart) bool {
+			return p.Filename == "report.pdf" && strings.HasPrefix(p.URL, "data:application/pdf;base64,")
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			part, err := AttachFile(tt.path)
+			if err != nil {
+				t.Fatalf("AttachFile failed: %v", err)
+			}
+			if part.Type != tt.wantType || !tt.check(part) {
+				t.Errorf("Unexpected part: %+v", part)
+			}
+		})
+	}
+
+	if _, err := AttachFile(filepath.Join(dir, "missing.png")); err == nil {
+		t.Error("Expected error for a missing file")
+	}
+}
+
+func TestConversation_SendMessageWithAttachments(t *testing.T) {
+	provider := &fakeProvider{}
+	conv := NewConversation(provider, DefaultConfig(), "System")
+
+	image := ImageData(pngHeader, "")
+	if _, err := conv.SendMessageWithAttachments(context.Background(), "What is this error?", image); err != nil {
+		t.Fatalf("SendMessageWithAttachments failed: %v", err)
+	}
+
+	sent := provider.requests[0].Messages[1]
+	if sent.Content != "What is this error?" || len(sent.Parts) != 1 || sent.Parts[0].URL != image.URL {
+		t.Errorf("Expected attachment on user message, got %+v", sent)
+	}
+	if history := conv.GetHistory(); len(history[1].Parts) != 1 {
+		t.Errorf("Expected attachment to be kept in history, got %+v", history[1])
+	}
+
+	// Retrying resends the attachment
+	conv.RetryLast(context.Background())
+	if retried := provider.requests[1].Messages[1]; len(retried.Parts) != 1 {
+		t.Errorf("Expected retry to keep the attachment, got %+v", retried)
+	}
+}
+
+func TestQuickQueryWithAttachments(t *testing.T) {
+	provider := &fakeProvider{}
+	_, err := QuickQueryWithAttachments(context.Background(), provider, DefaultConfig(), "Describe", "System", ImageURL("https://example.com/a.png"))
+	if err != nil {
+		t.Fatalf("QuickQueryWithAttachments failed: %v", err)
+	}
+
+	if parts := provider.requests[0].Messages[1].Parts; len(parts) != 1 || parts[0].URL != "https://example.com/a.png" {
+		t.Errorf("Unexpected parts: %+v", parts)
+	}
+}
+
+func TestOpenAIProvider_ContentParts(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"chatcmpl-5","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"a cat"}}]}`)
+	}))
+	defer server.Close()
+
+	client := openai.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL))
+	_, err := NewOpenAIProvider(&client).Complete(context.Background(), &Request{
+		Model:     "gpt-4o",
+		MaxTokens: 100,
+		Messages: []Message{{
+			Role:    "user",
+			Content: "What is this?",
+			Parts: []ContentPart{
+				{Type: PartImage, URL: "data:image/png;base64,AAAA", Detail: "low"},
+				FileData("report.pdf", []byte("%PDF"), "application/pdf"),
+				TextPart("extra"),
+			},
+		}},
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	content := body["messages"].([]interface{})[0].(map[string]interface{})["content"].([]interface{})
+	if len(content) != 4 {
+		t.Fatalf("Expected 4 content parts, got %v", content)
+	}
+
+	types := make([]string, len(content))
+	for i, part := range content {
+		types[i] = part.(map[string]interface{})["type"].(string)
+	}
+	if strings.Join(types, ",") != "text,image_url,file,text" {
+		t.Errorf("Unexpected part types: %v", types)
+	}
+
+	image := content[1].(map[string]interface{})["image_url"].(map[string]interface{})
+	if image["url"] != "data:image/png;base64,AAAA" || image["detail"] != "low" {
+		t.Errorf("Unexpected image part: %v", image)
+	}
+	file := content[2].(map[string]interface{})["file"].(map[string]interface{})
+	if file["filename"] != "report.pdf" || !strings.HasPrefix(file["file_data"].(string), "data:application/pdf;base64,") {
+		t.Errorf("Unexpected file part: %v", file)
+	}
+}
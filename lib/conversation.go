@@ -3,28 +3,44 @@ package lib
 import (
 	"context"
 	"fmt"
-
-	"github.com/openai/openai-go"
+	"reflect"
+	"sync"
 )
 
 // Message represents a single message in a conversation
 type Message struct {
-	Role    string
-	Content string
+	Role    string `json:"role"`
+	Content string `json:"content"`
+	// Parts holds images, files and extra text sent after Content in a user message
+	Parts []ContentPart `json:"parts,omitempty"`
+	// ToolCalls holds the tools requested by an assistant message
+	ToolCalls []ToolCall `json:"tool_calls,omitempty"`
+	// ToolCallID links a "tool" message to the call it answers
+	ToolCallID string `json:"tool_call_id,omitempty"`
 }
 
-// Conversation manages a multi-turn conversation with the AI
+// Conversation manages a multi-turn conversation with the AI. It is safe for
+// concurrent use: turns and edits run one at a time, in the order they acquire
+// the conversation, while reads such as GetHistory never wait for a turn in
+// flight and see the conversation as of the last completed change.
 type Conversation struct {
-	client   *openai.Client
+	// mu guards the fields below; busy is held for a whole turn or edit
+	mu   sync.Mutex
+	busy chan struct{}
+
+	client   Provider
 	config   *Config
-	messages []openai.ChatCompletionMessageParamUnion
+	messages []Message // Messages sent to the provider on each turn
 	history  []Message // Keep a simple history for easier access
+	usage    Usage     // Total usage of every completion in this conversation
+	tools    *ToolRegistry
+	strategy ContextStrategy
 }
 
 // NewConversation creates a new conversation with a system prompt
-func NewConversation(client *openai.Client, config *Config, systemPrompt string) *Conversation {
-	messages := []openai.ChatCompletionMessageParamUnion{
-		openai.SystemMessage(systemPrompt),
+func NewConversation(client Provider, config *Config, systemPrompt string) *Conversation {
+	messages := []Message{
+		{Role: "system", Content: systemPrompt},
 	}
 
 	history := []Message{
@@ -32,6 +48,7 @@ func NewConversation(client *openai.Client, config *Config, systemPrompt string)
 	}
 
 	return &Conversation{
+		busy:     make(chan struct{}, 1),
 		client:   client,
 		config:   config,
 		messages: messages,
@@ -39,62 +56,414 @@ func NewConversation(client *openai.Client, config *Config, systemPrompt string)
 	}
 }
 
-// SendMessage sends a user message and returns the AI's response
+// acquire waits until no other turn or edit is in progress. It gives up with
+// ctx's error if ctx is done first.
+func (c *Conversation) acquire(ctx context.Context) error {
+	c.mu.Lock()
+	if c.busy == nil {
+		c.busy = make(chan struct{}, 1)
+	}
+	busy := c.busy
+	c.mu.Unlock()
+
+	select {
+	case busy <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release ends the turn or edit started by acquire
+func (c *Conversation) release() {
+	<-c.busy
+}
+
+// lockEdit waits for any turn in flight, then locks the conversation state.
+// It returns the function that undoes both.
+func (c *Conversation) lockEdit() func() {
+	c.acquire(context.Background())
+	c.mu.Lock()
+	return func() {
+		c.mu.Unlock()
+		c.release()
+	}
+}
+
+// SetTools makes the tools in registry available to the model. Pass nil to
+// remove them.
+func (c *Conversation) SetTools(registry *ToolRegistry) {
+	defer c.lockEdit()()
+	c.tools = registry
+}
+
+// SetContextStrategy sets how the messages sent to the provider are trimmed
+// to fit the context window. The default, nil, sends every message.
+func (c *Conversation) SetContextStrategy(strategy ContextStrategy) {
+	defer c.lockEdit()()
+	c.strategy = strategy
+}
+
+// SendMessage sends a user message and returns the AI's response. If the
+// model calls tools, they are run and their results sent back until the
+// model answers, for up to config.MaxToolSteps rounds.
+//
+// If another turn is in flight, SendMessage waits for it to finish and then
+// sends with the updated conversation, or returns ctx's error if ctx is done
+// first. Tools must not send on the conversation that called them, since
+// that turn waits for the one running the tool.
 func (c *Conversation) SendMessage(ctx context.Context, message string) (string, error) {
-	// Add user message to conversation history
-	c.messages = append(c.messages, openai.UserMessage(message))
-	c.history = append(c.history, Message{Role: "user", Content: message})
+	return c.SendMessageWithAttachments(ctx, message)
+}
 
-	// Get AI response
-	params := openai.ChatCompletionNewParams{
-		Model:    c.config.Model,
-		Messages: c.messages,
+// SendMessageWithAttachments sends a user message with images, files or other
+// content parts attached, and returns the AI's response. It otherwise behaves
+// like SendMessage.
+func (c *Conversation) SendMessageWithAttachments(ctx context.Context, message string, attachments ...ContentPart) (string, error) {
+	if err := c.acquire(ctx); err != nil {
+		return "", err
 	}
+	defer c.release()
 
-	// Use different token parameter based on model
-	modelStr := string(c.config.Model)
-	if modelStr == "gpt-5-mini" {
-		params.MaxCompletionTokens = openai.Int(int64(c.config.MaxTokens))
-	} else {
-		params.MaxTokens = openai.Int(int64(c.config.MaxTokens))
+	return c.sendMessage(ctx, c.newTurn(Message{Role: "user", Content: message, Parts: attachments}))
+}
+
+// sendMessage runs t and records it; the caller must hold the conversation
+func (c *Conversation) sendMessage(ctx context.Context, t *turn) (string, error) {
+	err := c.runTurn(ctx, t, func(req *Request) (*Response, error) {
+		return complete(ctx, c.client, c.config, req)
+	})
+	c.finishTurn(t, err)
+	if err != nil {
+		return "", err
 	}
+	return t.resp.Content, nil
+}
+
+// SendMessageStream sends a user message and streams the AI's response. The
+// user message and complete reply are only added to the conversation once the
+// stream finishes successfully; if ctx is cancelled or the request fails the
+// conversation is left unchanged. Like SendMessage, the stream waits for any
+// turn in flight before sending, and holds the conversation until it finishes.
+func (c *Conversation) SendMessageStream(ctx context.Context, message string) *Stream {
+	userMessage := Message{Role: "user", Content: message}
 
-	resp, err := c.client.Chat.Completions.New(ctx, params)
+	var t *turn
+	return startStream(ctx, func(onDelta func(string)) (*Response, error) {
+		if err := c.acquire(ctx); err != nil {
+			return nil, err
+		}
+
+		t = c.newTurn(userMessage)
+		err := c.runTurn(ctx, t, func(req *Request) (*Response, error) {
+			return completeStream(ctx, c.client, c.config, req, onDelta)
+		})
+		if err != nil {
+			return nil, err
+		}
+		return t.resp, nil
+	}, func(resp *Response, err error) (*Response, error) {
+		if t == nil {
+			return nil, err
+		}
+		defer c.release()
+
+		c.finishTurn(t, err)
+		if err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+}
+
+// turn is the outcome of sending one user message
+type turn struct {
+	// base is the conversation the user message is sent after
+	base        []Message
+	userMessage Message
+	// replacesLast is set when the turn replaces the last exchange instead
+	// of following it, as in RetryLast
+	replacesLast bool
+	// exchange holds the tool call and tool result messages followed by the final reply
+	exchange []Message
+	resp     *Response
+	usage    Usage
+}
+
+// newTurn starts a turn sending userMessage after the current conversation.
+// The caller must hold the conversation.
+func (c *Conversation) newTurn(userMessage Message) *turn {
+	return &turn{base: c.messages, userMessage: userMessage}
+}
+
+// runTurn sends t's user message using send, running requested tools until
+// the model gives a final answer. The conversation is not modified; t always
+// carries the usage of every completion made. The caller must hold the
+// conversation, so no other goroutine changes the fields read here.
+func (c *Conversation) runTurn(ctx context.Context, t *turn, send func(req *Request) (*Response, error)) error {
+	tools := c.tools.Definitions()
+	if info, _ := LookupModel(c.config.Model); len(tools) > 0 && !info.SupportsTools {
+		return fmt.Errorf("model %s does not support tools", c.config.Model)
+	}
+
+	for step := 0; ; step++ {
+		req := newRequest(c.config, append(t.pendingMessages(), t.exchange...))
+		req.Tools = tools
+		if err := c.trim(ctx, t, req); err != nil {
+			return err
+		}
+
+		resp, err := send(req)
+		if err != nil {
+			return fmt.Errorf("API request failed (model: %s): %w", c.config.Model, err)
+		}
+		t.usage = t.usage.Add(resp.Usage)
+
+		if len(resp.ToolCalls) == 0 {
+			if err := checkResponse(c.config.Model, resp); err != nil {
+				return err
+			}
+			t.exchange = append(t.exchange, Message{Role: "assistant", Content: resp.Content})
+			t.resp = resp
+			return nil
+		}
+
+		if step >= c.config.MaxToolSteps {
+			return fmt.Errorf("%w (%d rounds, model: %s)", ErrMaxToolSteps, step, c.config.Model)
+		}
+
+		t.exchange = append(t.exchange, Message{Role: "assistant", Content: resp.Content, ToolCalls: resp.ToolCalls})
+		for _, call := range resp.ToolCalls {
+			result := fmt.Sprintf("Error: unknown tool %q", call.Name)
+			if c.tools != nil {
+				result = c.tools.run(ctx, call)
+			}
+			t.exchange = append(t.exchange, Message{Role: "tool", Content: result, ToolCallID: call.ID})
+		}
+	}
+}
+
+// SendStructured sends a user message and decodes the reply into target,
+// which must be a non-nil pointer to a struct. The turn uses a JSON schema
+// response format with the same validation repair and truncation retries as
+// StructuredQuery, and records the accepted JSON as the assistant reply, so
+// later turns can refer back to it. Tools are not offered on structured turns.
+func (c *Conversation) SendStructured(ctx context.Context, message string, target interface{}) error {
+	return c.SendStructuredWithAttachments(ctx, message, target)
+}
+
+// SendStructuredWithAttachments is SendStructured with images, files or other
+// content parts attached to the user message
+func (c *Conversation) SendStructuredWithAttachments(ctx context.Context, message string, target interface{}, attachments ...ContentPart) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("structured query target must be a non-nil pointer to a struct, got %T", target)
+	}
 
+	if err := c.acquire(ctx); err != nil {
+		return err
+	}
+	defer c.release()
+
+	schema, err := structuredSchema(c.config, v.Type().Elem())
 	if err != nil {
-		return "", fmt.Errorf("API request failed (model: %s): %w", c.config.Model, err)
+		return err
 	}
 
-	if len(resp.Choices) == 0 {
-		return "", fmt.Errorf("no response choices returned from API (model: %s, id: %s)", c.config.Model, resp.ID)
+	t := c.newTurn(Message{Role: "user", Content: message, Parts: attachments})
+	content, err := decodeStructured(c.config, t.pendingMessages(), schema, v.Type().Elem(), target, func(req *Request) (*Response, error) {
+		if err := c.trim(ctx, t, req); err != nil {
+			return nil, err
+		}
+		resp, err := complete(ctx, c.client, c.config, req)
+		if err != nil {
+			return nil, fmt.Errorf("API request failed (model: %s): %w", c.config.Model, err)
+		}
+		t.usage = t.usage.Add(resp.Usage)
+		return resp, nil
+	})
+	if err == nil {
+		t.exchange = []Message{{Role: "assistant", Content: content}}
 	}
+	c.finishTurn(t, err)
+	return err
+}
 
-	// Add AI response to conversation history
-	aiResponse := resp.Choices[0].Message.Content
-	if aiResponse == "" {
-		return "", fmt.Errorf("empty response content from API (model: %s, finish_reason: %s, id: %s)", c.config.Model, resp.Choices[0].FinishReason, resp.ID)
+// trim applies the conversation's context strategy to req, adding any usage
+// it reports to t
+func (c *Conversation) trim(ctx context.Context, t *turn, req *Request) error {
+	if c.strategy == nil {
+		return nil
 	}
-	c.messages = append(c.messages, openai.AssistantMessage(aiResponse))
-	c.history = append(c.history, Message{Role: "assistant", Content: aiResponse})
 
-	return aiResponse, nil
+	messages, usage, err := c.strategy.Trim(ctx, req)
+	t.usage = t.usage.Add(usage)
+	if err != nil {
+		return err
+	}
+	req.Messages = messages
+	return nil
+}
+
+// pendingMessages returns a new slice of the messages to send for the turn's
+// user message
+func (t *turn) pendingMessages() []Message {
+	messages := make([]Message, 0, len(t.base)+1)
+	messages = append(messages, t.base...)
+	return append(messages, t.userMessage)
+}
+
+// finishTurn adds the turn's usage to the conversation and, if err is nil,
+// records the exchange, replacing the last one if t.replacesLast is set.
+// Tool messages are kept in the context sent to the provider but left out
+// of the history.
+func (c *Conversation) finishTurn(t *turn, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.usage = c.usage.Add(t.usage)
+	if err != nil {
+		return
+	}
+	if t.replacesLast {
+		c.popTurn()
+	}
+	c.messages = append(c.messages, t.userMessage)
+	c.messages = append(c.messages, t.exchange...)
+	c.history = append(c.history, t.userMessage, t.exchange[len(t.exchange)-1])
 }
 
-// GetHistory returns the conversation history as a slice of Messages
+// GetHistory returns a copy of the conversation history
 func (c *Conversation) GetHistory() []Message {
-	return c.history
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return copyMessages(c.history)
+}
+
+// Usage returns the total token usage of this conversation so far
+func (c *Conversation) Usage() Usage {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.usage
+}
+
+// SetSystemPrompt replaces the system prompt, adding one if the conversation
+// has none. Earlier turns are kept.
+func (c *Conversation) SetSystemPrompt(systemPrompt string) {
+	defer c.lockEdit()()
+
+	systemMessage := Message{Role: "system", Content: systemPrompt}
+	if len(c.messages) > 0 && c.messages[0].Role == "system" {
+		c.messages[0] = systemMessage
+		c.history[0] = systemMessage
+		return
+	}
+
+	c.messages = append([]Message{systemMessage}, c.messages...)
+	c.history = append([]Message{systemMessage}, c.history...)
+}
+
+// Undo removes the last exchange: the user message, any tool calls it led to,
+// and the reply. It returns ErrNoTurns if there is nothing to remove.
+func (c *Conversation) Undo() error {
+	defer c.lockEdit()()
+
+	_, err := c.popTurn()
+	return err
+}
+
+// RetryLast sends the last user message again and replaces the last reply
+// with the new one. Until the new reply arrives, the conversation keeps the
+// previous one, and if the retry fails it is kept for good.
+func (c *Conversation) RetryLast(ctx context.Context) (string, error) {
+	if err := c.acquire(ctx); err != nil {
+		return "", err
+	}
+	defer c.release()
+
+	c.mu.Lock()
+	last, err := c.lastTurn()
+	c.mu.Unlock()
+	if err != nil {
+		return "", err
+	}
+
+	t := &turn{base: c.messages[:last], userMessage: c.messages[last], replacesLast: true}
+	return c.sendMessage(ctx, t)
+}
+
+// lastTurn returns the index in c.messages of the last user message. The
+// caller must hold c.mu.
+func (c *Conversation) lastTurn() (int, error) {
+	for i := len(c.messages) - 1; i >= 0; i-- {
+		if c.messages[i].Role == "user" && len(c.history) >= 2 {
+			return i, nil
+		}
+	}
+	return -1, ErrNoTurns
+}
+
+// popTurn removes the last exchange and returns its user message. The
+// slices are reallocated so earlier copies of them stay intact. The caller
+// must hold c.mu.
+func (c *Conversation) popTurn() (Message, error) {
+	last, err := c.lastTurn()
+	if err != nil {
+		return Message{}, err
+	}
+
+	userMessage := c.messages[last]
+	c.messages = append([]Message(nil), c.messages[:last]...)
+	c.history = append([]Message(nil), c.history[:len(c.history)-2]...)
+	return userMessage, nil
+}
+
+// Fork returns an independent copy of the conversation that shares the
+// client, tools and context strategy. A strategy that implements
+// ForkableStrategy, such as Summarizer, is forked instead of shared. Turns
+// sent to either conversation don't affect the other.
+func (c *Conversation) Fork() *Conversation {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	strategy := c.strategy
+	if forkable, ok := strategy.(ForkableStrategy); ok {
+		strategy = forkable.Fork()
+	}
+	config := *c.config
+	return &Conversation{
+		busy:     make(chan struct{}, 1),
+		client:   c.client,
+		config:   &config,
+		messages: copyMessages(c.messages),
+		history:  copyMessages(c.history),
+		usage:    c.usage,
+		tools:    c.tools,
+		strategy: strategy,
+	}
+}
+
+// copyMessages returns a deep copy of messages
+func copyMessages(messages []Message) []Message {
+	result := make([]Message, len(messages))
+	for i, msg := range messages {
+		msg.Parts = append([]ContentPart(nil), msg.Parts...)
+		msg.ToolCalls = append([]ToolCall(nil), msg.ToolCalls...)
+		result[i] = msg
+	}
+	return result
 }
 
 // Reset clears the conversation history except for the system message
 func (c *Conversation) Reset() {
+	defer c.lockEdit()()
+
 	if len(c.history) > 0 && c.history[0].Role == "system" {
 		systemMessage := c.history[0]
-		c.messages = []openai.ChatCompletionMessageParamUnion{
-			openai.SystemMessage(systemMessage.Content),
-		}
+		c.messages = []Message{systemMessage}
 		c.history = []Message{systemMessage}
 	} else {
-		c.messages = []openai.ChatCompletionMessageParamUnion{}
+		c.messages = []Message{}
 		c.history = []Message{}
 	}
 }
@@ -0,0 +1,233 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// echoProvider is a concurrency-safe Provider that replies to the last
+// message. If gate is set, each call waits for a value from it first.
+type echoProvider struct {
+	mu       sync.Mutex
+	requests []*Request
+	started  chan struct{}
+	gate     chan struct{}
+}
+
+func (p *echoProvider) Complete(ctx context.Context, req *Request) (*Response, error) {
+	p.mu.Lock()
+	p.requests = append(p.requests, req)
+	p.mu.Unlock()
+
+	if p.started != nil {
+		p.started <- struct{}{}
+	}
+	if p.gate != nil {
+		select {
+		case <-p.gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	last := req.Messages[len(req.Messages)-1]
+	return &Response{ID: "echo", Content: "reply to " + last.Content, FinishReason: "stop", Usage: Usage{TotalTokens: 1}}, nil
+}
+
+func (p *echoProvider) requestCount() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return len(p.requests)
+}
+
+func TestConversation_ConcurrentSends(t *testing.T) {
+	conv := NewConversation(&echoProvider{}, DefaultConfig(), "System")
+	ctx := context.Background()
+
+	const senders = 20
+	var wg sync.WaitGroup
+	for i := 0; i < senders; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			if _, err := conv.SendMessage(ctx, fmt.Sprintf("message %d", i)); err != nil {
+				t.Errorf("SendMessage failed: %v", err)
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			conv.GetHistory()
+			conv.Usage()
+			if _, err := conv.MarshalJSON(); err != nil {
+				t.Errorf("MarshalJSON failed: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	history := conv.GetHistory()
+	if len(history) != 1+2*senders {
+		t.Fatalf("Expected %d messages, got %d", 1+2*senders, len(history))
+	}
+	// Serialised sends keep every reply next to its own question
+	for i := 1; i < len(history); i += 2 {
+		if history[i+1].Content != "reply to "+history[i].Content {
+			t.Errorf("Turn %d is interleaved: %q then %q", i/2, history[i].Content, history[i+1].Content)
+		}
+	}
+	if conv.Usage().TotalTokens != senders {
+		t.Errorf("Expected %d total tokens, got %d", senders, conv.Usage().TotalTokens)
+	}
+}
+
+func TestConversation_SendWaitsForTurnInFlight(t *testing.T) {
+	provider := &echoProvider{started: make(chan struct{}, 2), gate: make(chan struct{})}
+	conv := NewConversation(provider, DefaultConfig(), "System")
+
+	first := make(chan error)
+	go func() {
+		_, err := conv.SendMessage(context.Background(), "first")
+		first <- err
+	}()
+	<-provider.started
+
+	// A second send gives up once its context is done
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := conv.SendMessage(ctx, "impatient"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected DeadlineExceeded while a turn is in flight, got %v", err)
+	}
+	if provider.requestCount() != 1 {
+		t.Errorf("Expected the waiting send not to reach the provider, got %d requests", provider.requestCount())
+	}
+
+	// A patient send runs after the first turn, with it as context
+	second := make(chan error)
+	go func() {
+		_, err := conv.SendMessage(context.Background(), "second")
+		second <- err
+	}()
+
+	provider.gate <- struct{}{}
+	if err := <-first; err != nil {
+		t.Fatalf("First SendMessage failed: %v", err)
+	}
+	<-provider.started
+	provider.gate <- struct{}{}
+	if err := <-second; err != nil {
+		t.Fatalf("Second SendMessage failed: %v", err)
+	}
+
+	sent := provider.requests[1].Messages
+	if len(sent) != 4 || sent[1].Content != "first" || sent[3].Content != "second" {
+		t.Errorf("Expected the second turn to include the first, got %+v", sent)
+	}
+}
+
+func TestConversation_StreamWaitsForTurnInFlight(t *testing.T) {
+	provider := &echoProvider{started: make(chan struct{}, 2), gate: make(chan struct{})}
+	conv := NewConversation(provider, DefaultConfig(), "System")
+
+	first := make(chan error)
+	go func() {
+		_, err := conv.SendMessage(context.Background(), "first")
+		first <- err
+	}()
+	<-provider.started
+
+	stream := conv.SendMessageStream(context.Background(), "streamed")
+	provider.gate <- struct{}{}
+	<-first
+	<-provider.started
+	provider.gate <- struct{}{}
+
+	resp, err := stream.Wait()
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+	if resp.Content != "reply to streamed" || len(conv.GetHistory()) != 5 {
+		t.Errorf("Unexpected result after queued stream: %+v, history %+v", resp, conv.GetHistory())
+	}
+}
+
+func TestConversation_ReadsDuringRetryLast(t *testing.T) {
+	provider := &echoProvider{}
+	conv := NewConversation(provider, DefaultConfig(), "System")
+	if _, err := conv.SendMessage(context.Background(), "first"); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	provider.started, provider.gate = make(chan struct{}, 1), make(chan struct{})
+	retried := make(chan error)
+	go func() {
+		_, err := conv.RetryLast(context.Background())
+		retried <- err
+	}()
+	<-provider.started
+
+	// Until the retry finishes, readers still see the last exchange
+	if history := conv.GetHistory(); len(history) != 3 || history[2].Content != "reply to first" {
+		t.Errorf("Expected the last exchange while retrying, got %+v", history)
+	}
+	saved, err := conv.MarshalJSON()
+	if err != nil || !strings.Contains(string(saved), "reply to first") {
+		t.Errorf("Expected a save while retrying to keep the last exchange, got %s, %v", saved, err)
+	}
+	if history := conv.Fork().GetHistory(); len(history) != 3 {
+		t.Errorf("Expected a fork while retrying to keep the last exchange, got %+v", history)
+	}
+
+	provider.gate <- struct{}{}
+	if err := <-retried; err != nil {
+		t.Fatalf("RetryLast failed: %v", err)
+	}
+	if sent := provider.requests[1].Messages; len(sent) != 2 || sent[1].Content != "first" {
+		t.Errorf("Expected the retry to resend the last user message, got %+v", sent)
+	}
+	if history := conv.GetHistory(); len(history) != 3 || history[2].Content != "reply to first" {
+		t.Errorf("Expected the new reply to replace the old one, got %+v", history)
+	}
+}
+
+func TestConversation_ConcurrentEdits(t *testing.T) {
+	conv := NewConversation(&echoProvider{}, DefaultConfig(), "System")
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(5)
+		go func() { defer wg.Done(); conv.SendMessage(ctx, "hello") }()
+		go func() { defer wg.Done(); conv.Undo() }()
+		go func() { defer wg.Done(); conv.SetSystemPrompt(fmt.Sprintf("System %d", i)) }()
+		go func() { defer wg.Done(); conv.Fork().SendMessage(ctx, "fork") }()
+		go func() { defer wg.Done(); conv.RetryLast(ctx) }()
+	}
+	wg.Wait()
+
+	history := conv.GetHistory()
+	if len(history)%2 != 1 || !strings.HasPrefix(history[0].Content, "System") {
+		t.Fatalf("Expected a system message followed by whole turns, got %+v", history)
+	}
+	for i := 1; i < len(history); i += 2 {
+		if history[i].Role != "user" || history[i+1].Role != "assistant" {
+			t.Errorf("Unexpected turn at %d: %+v", i, history[i:i+2])
+		}
+	}
+}
+
+func TestConversation_GetHistoryReturnsCopy(t *testing.T) {
+	conv := NewConversation(&fakeProvider{}, DefaultConfig(), "System")
+	conv.SendMessage(context.Background(), "Hello")
+
+	history := conv.GetHistory()
+	history[0].Content = "Tampered"
+
+	if got := conv.GetHistory(); got[0].Content != "System" || len(got) != 3 {
+		t.Errorf("Expected internal history to be unaffected, got %+v", got)
+	}
+}
@@ -2,14 +2,14 @@ package lib
 
 import (
 	"context"
-	"os"
+	"errors"
 	"testing"
 
 	"github.com/openai/openai-go"
 )
 
 func TestNewConversation(t *testing.T) {
-	client := &openai.Client{}
+	client := NewOpenAIProvider(&openai.Client{})
 	config := DefaultConfig()
 	systemPrompt := "You are a helpful assistant."
 
@@ -42,7 +42,7 @@ func TestNewConversation(t *testing.T) {
 }
 
 func TestConversation_GetHistory(t *testing.T) {
-	client := &openai.Client{}
+	client := NewOpenAIProvider(&openai.Client{})
 	config := DefaultConfig()
 	conv := NewConversation(client, config, "System prompt")
 
@@ -58,7 +58,7 @@ func TestConversation_GetHistory(t *testing.T) {
 }
 
 func TestConversation_Reset(t *testing.T) {
-	client := &openai.Client{}
+	client := NewOpenAIProvider(&openai.Client{})
 	config := DefaultConfig()
 	systemPrompt := "System prompt"
 	conv := NewConversation(client, config, systemPrompt)
@@ -68,8 +68,8 @@ func TestConversation_Reset(t *testing.T) {
 		Message{Role: "assistant", Content: "Hi there"},
 	)
 	conv.messages = append(conv.messages,
-		openai.UserMessage("Hello"),
-		openai.AssistantMessage("Hi there"),
+		Message{Role: "user", Content: "Hello"},
+		Message{Role: "assistant", Content: "Hi there"},
 	)
 
 	if len(conv.GetHistory()) != 3 {
@@ -93,12 +93,12 @@ func TestConversation_Reset(t *testing.T) {
 }
 
 func TestConversation_ResetWithoutSystemMessage(t *testing.T) {
-	client := &openai.Client{}
+	client := NewOpenAIProvider(&openai.Client{})
 	config := DefaultConfig()
 	conv := &Conversation{
 		client:   client,
 		config:   config,
-		messages: []openai.ChatCompletionMessageParamUnion{},
+		messages: []Message{},
 		history:  []Message{},
 	}
 
@@ -115,7 +115,7 @@ func TestConversation_ResetWithoutSystemMessage(t *testing.T) {
 }
 
 func TestConversation_MessageHistory(t *testing.T) {
-	client := &openai.Client{}
+	client := NewOpenAIProvider(&openai.Client{})
 	config := DefaultConfig()
 	conv := NewConversation(client, config, "System")
 
@@ -127,7 +127,7 @@ func TestConversation_MessageHistory(t *testing.T) {
 	conv.history = append(conv.history,
 		Message{Role: "user", Content: "First message"},
 	)
-	conv.messages = append(conv.messages, openai.UserMessage("First message"))
+	conv.messages = append(conv.messages, Message{Role: "user", Content: "First message"})
 
 	history := conv.GetHistory()
 	if len(history) != 2 {
@@ -140,7 +140,7 @@ func TestConversation_MessageHistory(t *testing.T) {
 }
 
 func TestConversation_ModelTokenParameterGPT5Mini(t *testing.T) {
-	client := &openai.Client{}
+	client := NewOpenAIProvider(&openai.Client{})
 	config := &Config{
 		Model:     "gpt-5-mini",
 		MaxTokens: 500,
@@ -153,7 +153,7 @@ func TestConversation_ModelTokenParameterGPT5Mini(t *testing.T) {
 }
 
 func TestConversation_ModelTokenParameterOther(t *testing.T) {
-	client := &openai.Client{}
+	client := NewOpenAIProvider(&openai.Client{})
 	config := &Config{
 		Model:     "gpt-4o",
 		MaxTokens: 500,
@@ -165,121 +165,237 @@ func TestConversation_ModelTokenParameterOther(t *testing.T) {
 	}
 }
 
-// Integration test (requires OPENAI_API_KEY)
-func TestConversation_SendMessage_Integration(t *testing.T) {
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Skip("Skipping integration test: OPENAI_API_KEY not set")
+func TestConversation_SendMessage(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{{ID: "1", Content: "Hi there", FinishReason: "stop"}},
 	}
+	conv := NewConversation(provider, DefaultConfig(), "System")
 
-	client, config, err := NewClientFromEnv()
+	response, err := conv.SendMessage(context.Background(), "Hello")
 	if err != nil {
-		t.Fatalf("Failed to create client: %v", err)
+		t.Fatalf("SendMessage failed: %v", err)
 	}
 
-	conv := NewConversation(client, config, "You are a helpful assistant. Keep responses very brief.")
-
-	ctx := context.Background()
-	response, err := conv.SendMessage(ctx, "Say 'hello' and nothing else")
+	if response != "Hi there" {
+		t.Errorf("Expected response 'Hi there', got '%s'", response)
+	}
 
-	if err != nil {
-		t.Fatalf("SendMessage failed: %v", err)
+	if len(provider.requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(provider.requests))
 	}
 
-	if response == "" {
-		t.Error("Expected non-empty response")
+	sent := provider.requests[0].Messages
+	if len(sent) != 2 || sent[0].Role != "system" || sent[1].Content != "Hello" {
+		t.Errorf("Unexpected messages sent to provider: %+v", sent)
 	}
 
 	history := conv.GetHistory()
-	if len(history) != 3 {
-		t.Errorf("Expected 3 messages (system, user, assistant), got %d", len(history))
+	if len(history) != 3 || history[2].Role != "assistant" || history[2].Content != "Hi there" {
+		t.Errorf("Unexpected history after SendMessage: %+v", history)
 	}
+}
 
-	if history[1].Role != "user" {
-		t.Errorf("Expected second message to be user, got %s", history[1].Role)
+func TestConversation_SendMessage_EmptyContent(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{{ID: "1", Content: "", FinishReason: "length"}},
 	}
+	conv := NewConversation(provider, DefaultConfig(), "System")
 
-	if history[2].Role != "assistant" {
-		t.Errorf("Expected third message to be assistant, got %s", history[2].Role)
+	_, err := conv.SendMessage(context.Background(), "Hello")
+	if err == nil {
+		t.Fatal("Expected error for empty response content")
 	}
 }
 
-// Integration test (requires OPENAI_API_KEY)
-func TestConversation_MultiTurn_Integration(t *testing.T) {
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Skip("Skipping integration test: OPENAI_API_KEY not set")
+func TestConversation_Undo(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{
+			{ID: "1", Content: "first", FinishReason: "stop"},
+			{ID: "2", FinishReason: "tool_calls", ToolCalls: []ToolCall{{ID: "call_1", Name: "add", Arguments: `{"a":1,"b":1}`}}},
+			{ID: "3", Content: "second", FinishReason: "stop"},
+		},
 	}
+	conv := NewConversation(provider, DefaultConfig(), "System")
+	conv.SetTools(newAddRegistry(t))
 
-	client, config, err := NewClientFromEnv()
-	if err != nil {
-		t.Fatalf("Failed to create client: %v", err)
+	for _, msg := range []string{"one", "two"} {
+		if _, err := conv.SendMessage(context.Background(), msg); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
 	}
 
-	conv := NewConversation(client, config, "You are a helpful assistant. Remember what the user tells you.")
+	if err := conv.Undo(); err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+	if len(conv.messages) != 3 || len(conv.history) != 3 || conv.history[2].Content != "first" {
+		t.Errorf("Expected only the first exchange to remain, got messages %+v, history %+v", conv.messages, conv.history)
+	}
 
-	ctx := context.Background()
+	if err := conv.Undo(); err != nil {
+		t.Fatalf("Undo failed: %v", err)
+	}
+	if err := conv.Undo(); !errors.Is(err, ErrNoTurns) {
+		t.Errorf("Expected ErrNoTurns, got %v", err)
+	}
+	if len(conv.messages) != 1 || len(conv.history) != 1 {
+		t.Errorf("Expected only the system message to remain, got %+v", conv.history)
+	}
+}
 
-	_, err = conv.SendMessage(ctx, "My name is Alice.")
-	if err != nil {
-		t.Fatalf("First message failed: %v", err)
+func TestConversation_RetryLast(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{
+			{ID: "1", Content: "first answer", FinishReason: "stop"},
+			{ID: "2", Content: "second answer", FinishReason: "stop"},
+		},
+	}
+	conv := NewConversation(provider, DefaultConfig(), "System")
+
+	if _, err := conv.RetryLast(context.Background()); !errors.Is(err, ErrNoTurns) {
+		t.Errorf("Expected ErrNoTurns, got %v", err)
 	}
 
-	response, err := conv.SendMessage(ctx, "What is my name?")
+	conv.SendMessage(context.Background(), "question")
+	reply, err := conv.RetryLast(context.Background())
 	if err != nil {
-		t.Fatalf("Second message failed: %v", err)
+		t.Fatalf("RetryLast failed: %v", err)
+	}
+
+	if reply != "second answer" {
+		t.Errorf("Expected regenerated reply, got %q", reply)
+	}
+	if sent := provider.requests[1].Messages; len(sent) != 2 || sent[1].Content != "question" {
+		t.Errorf("Expected the last question to be resent without the old answer, got %+v", sent)
+	}
+	history := conv.GetHistory()
+	if len(history) != 3 || history[2].Content != "second answer" {
+		t.Errorf("Unexpected history after retry: %+v", history)
+	}
+}
+
+func TestConversation_RetryLast_FailureKeepsReply(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{
+			{ID: "1", Content: "answer", FinishReason: "stop"},
+			{ID: "2", Content: "", FinishReason: "stop"},
+		},
 	}
+	conv := NewConversation(provider, DefaultConfig(), "System")
+	conv.SendMessage(context.Background(), "question")
 
-	if response == "" {
-		t.Error("Expected non-empty response")
+	if _, err := conv.RetryLast(context.Background()); !errors.Is(err, ErrEmptyContent) {
+		t.Fatalf("Expected ErrEmptyContent, got %v", err)
 	}
 
 	history := conv.GetHistory()
-	if len(history) != 5 {
-		t.Errorf("Expected 5 messages (system + 2 user + 2 assistant), got %d", len(history))
+	if len(history) != 3 || history[2].Content != "answer" || len(conv.messages) != 3 {
+		t.Errorf("Expected the previous reply to be kept, got %+v", history)
 	}
 }
 
-// Integration test for conversation reset (requires OPENAI_API_KEY)
-func TestConversation_ResetPreservesSystemMessage_Integration(t *testing.T) {
-	if os.Getenv("OPENAI_API_KEY") == "" {
-		t.Skip("Skipping integration test: OPENAI_API_KEY not set")
+func TestConversation_Fork(t *testing.T) {
+	provider := &fakeProvider{}
+	conv := NewConversation(provider, DefaultConfig(), "System")
+	conv.SendMessage(context.Background(), "shared")
+
+	fork := conv.Fork()
+	fork.SetSystemPrompt("Fork system")
+	fork.config.Model = "gpt-4o"
+	fork.SendMessage(context.Background(), "fork only")
+
+	if fork.client != conv.client {
+		t.Error("Expected fork to share the client")
+	}
+	if len(conv.GetHistory()) != 3 || conv.GetHistory()[0].Content != "System" || conv.config.Model != "gpt-5-mini" {
+		t.Errorf("Expected original to be unaffected by the fork, got %+v", conv.GetHistory())
 	}
+	if len(fork.GetHistory()) != 5 || fork.GetHistory()[0].Content != "Fork system" {
+		t.Errorf("Unexpected fork history: %+v", fork.GetHistory())
+	}
+}
 
-	client, config, err := NewClientFromEnv()
-	if err != nil {
-		t.Fatalf("Failed to create client: %v", err)
+func TestConversation_SetSystemPrompt(t *testing.T) {
+	conv := NewConversation(&fakeProvider{}, DefaultConfig(), "Old")
+	conv.SendMessage(context.Background(), "Hello")
+
+	conv.SetSystemPrompt("New")
+	if conv.messages[0].Content != "New" || conv.history[0].Content != "New" || len(conv.history) != 3 {
+		t.Errorf("Expected system prompt to be replaced in place, got %+v", conv.history)
 	}
 
-	systemPrompt := "You are a helpful assistant."
-	conv := NewConversation(client, config, systemPrompt)
+	noSystem := &Conversation{config: DefaultConfig(), messages: []Message{}, history: []Message{}}
+	noSystem.SetSystemPrompt("Added")
+	if len(noSystem.messages) != 1 || noSystem.history[0].Role != "system" {
+		t.Errorf("Expected system prompt to be added, got %+v", noSystem.history)
+	}
+}
 
-	ctx := context.Background()
+func TestConversation_SendStructured(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{
+			{ID: "1", Content: `{"answer":"4","score":9}`, FinishReason: "stop", Usage: Usage{TotalTokens: 10}},
+			{ID: "2", Content: `{"answer":"8","score":8}`, FinishReason: "stop", Usage: Usage{TotalTokens: 12}},
+		},
+	}
+	conv := NewConversation(provider, DefaultConfig(), "System")
 
-	_, err = conv.SendMessage(ctx, "Hello")
-	if err != nil {
-		t.Fatalf("SendMessage failed: %v", err)
+	var first structuredAnswer
+	if err := conv.SendStructured(context.Background(), "2+2?", &first); err != nil {
+		t.Fatalf("SendStructured failed: %v", err)
+	}
+	if first.Answer != "4" || first.Score != 9 {
+		t.Errorf("Unexpected result: %+v", first)
+	}
+	if schema := provider.requests[0].Schema; schema == nil || schema.Name != "structuredanswer" {
+		t.Errorf("Expected a response schema on the structured turn, got %+v", schema)
 	}
 
-	if len(conv.GetHistory()) != 3 {
-		t.Errorf("Expected 3 messages before reset, got %d", len(conv.GetHistory()))
+	var second structuredAnswer
+	if err := conv.SendStructured(context.Background(), "Double it", &second); err != nil {
+		t.Fatalf("SendStructured failed: %v", err)
 	}
 
-	conv.Reset()
+	sent := provider.requests[1].Messages
+	if len(sent) != 4 || sent[2].Role != "assistant" || sent[2].Content != `{"answer":"4","score":9}` {
+		t.Errorf("Expected the first JSON reply as context, got %+v", sent)
+	}
+	if history := conv.GetHistory(); len(history) != 5 || history[4].Content != `{"answer":"8","score":8}` {
+		t.Errorf("Unexpected history: %+v", history)
+	}
+	if conv.Usage().TotalTokens != 22 {
+		t.Errorf("Expected 22 total tokens, got %d", conv.Usage().TotalTokens)
+	}
+}
 
-	history := conv.GetHistory()
-	if len(history) != 1 {
-		t.Errorf("Expected 1 message after reset, got %d", len(history))
+func TestConversation_SendStructured_Repair(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{
+			{ID: "1", Content: `{"answer":""}`, FinishReason: "stop"},
+			{ID: "2", Content: `{"answer":"4"}`, FinishReason: "stop"},
+		},
 	}
+	conv := NewConversation(provider, DefaultConfig(), "System")
 
-	if history[0].Role != "system" || history[0].Content != systemPrompt {
-		t.Error("System message not preserved after reset")
+	var result validatedAnswer
+	if err := conv.SendStructured(context.Background(), "2+2?", &result); err != nil {
+		t.Fatalf("SendStructured failed: %v", err)
 	}
 
-	_, err = conv.SendMessage(ctx, "New conversation")
-	if err != nil {
-		t.Fatalf("SendMessage after reset failed: %v", err)
+	// Only the accepted reply is recorded
+	history := conv.GetHistory()
+	if len(history) != 3 || history[2].Content != `{"answer":"4"}` || len(conv.messages) != 3 {
+		t.Errorf("Unexpected history after repair: %+v", history)
 	}
+}
 
-	if len(conv.GetHistory()) != 3 {
-		t.Errorf("Expected 3 messages after new message, got %d", len(conv.GetHistory()))
+func TestConversation_SendStructured_InvalidTarget(t *testing.T) {
+	conv := NewConversation(&fakeProvider{}, DefaultConfig(), "System")
+
+	var result structuredAnswer
+	if err := conv.SendStructured(context.Background(), "2+2?", result); err == nil {
+		t.Error("Expected error for non-pointer target")
+	}
+	if len(conv.GetHistory()) != 1 {
+		t.Error("Expected conversation to be unchanged")
 	}
 }
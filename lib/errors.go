@@ -0,0 +1,168 @@
+package lib
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Sentinel errors for completions that returned no usable content. They are
+// wrapped in a *ResponseError, so match them with errors.Is.
+var (
+	ErrNoChoices     = errors.New("no response choices returned from API")
+	ErrEmptyContent  = errors.New("empty response content from API")
+	ErrRefusal       = errors.New("model refused the request")
+	ErrTruncated     = errors.New("response truncated by token limit")
+	ErrContentFilter = errors.New("response blocked by content filter")
+)
+
+// ErrNoTurns is returned when undoing or retrying a conversation with no turns
+var ErrNoTurns = errors.New("conversation has no turns")
+
+// ErrMaxToolSteps is returned when the model keeps calling tools after
+// Config.MaxToolSteps rounds
+var ErrMaxToolSteps = errors.New("tool call limit reached without a final answer")
+
+// ResponseError describes a completion that succeeded at the HTTP level but
+// produced no usable content
+type ResponseError struct {
+	// Err is one of the sentinel errors above
+	Err          error
+	Model        string
+	ResponseID   string
+	FinishReason string
+	// Refusal holds the model's refusal message when Err is ErrRefusal
+	Refusal string
+	// Content holds whatever was generated before the cut-off when Err is
+	// ErrTruncated
+	Content string
+}
+
+func (e *ResponseError) Error() string {
+	msg := fmt.Sprintf("%v (model: %s, finish_reason: %s, id: %s)", e.Err, e.Model, e.FinishReason, e.ResponseID)
+	if e.Refusal != "" {
+		msg += ": " + e.Refusal
+	}
+	return msg
+}
+
+func (e *ResponseError) Unwrap() error {
+	return e.Err
+}
+
+// checkResponse returns a *ResponseError if resp has no usable content or
+// was cut off by the token limit
+func checkResponse(model string, resp *Response) error {
+	respErr := &ResponseError{
+		Model:        model,
+		ResponseID:   resp.ID,
+		FinishReason: resp.FinishReason,
+		Refusal:      resp.Refusal,
+	}
+	switch {
+	case resp.Refusal != "":
+		respErr.Err = ErrRefusal
+	case resp.FinishReason == "content_filter":
+		respErr.Err = ErrContentFilter
+	case resp.FinishReason == "length":
+		respErr.Err = ErrTruncated
+		respErr.Content = resp.Content
+	case resp.Content == "":
+		respErr.Err = ErrEmptyContent
+	default:
+		return nil
+	}
+	return respErr
+}
+
+// APIError describes a request the provider rejected with an HTTP error status
+type APIError struct {
+	StatusCode int
+	// RetryAfter is the delay requested by the server, or zero if none was given
+	RetryAfter time.Duration
+	Err        error
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("API request failed with status %d %s: %v", e.StatusCode, http.StatusText(e.StatusCode), e.Err)
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// RateLimitError is returned when the provider rejects a request with 429 Too Many Requests
+type RateLimitError struct {
+	APIError
+}
+
+// AuthError is returned when the provider rejects the API key (401 or 403)
+type AuthError struct {
+	APIError
+}
+
+// ServerError is returned when the provider fails with a 5xx status
+type ServerError struct {
+	APIError
+}
+
+// newStatusError wraps err in the typed error matching statusCode
+func newStatusError(statusCode int, header http.Header, err error) error {
+	apiErr := APIError{
+		StatusCode: statusCode,
+		RetryAfter: parseRetryAfter(header, time.Now()),
+		Err:        err,
+	}
+
+	switch {
+	case statusCode == http.StatusTooManyRequests:
+		return &RateLimitError{apiErr}
+	case statusCode == http.StatusUnauthorized || statusCode == http.StatusForbidden:
+		return &AuthError{apiErr}
+	case statusCode >= 500:
+		return &ServerError{apiErr}
+	default:
+		return &apiErr
+	}
+}
+
+// apiError lets asAPIError match APIError and every typed error embedding it
+func (e *APIError) apiError() *APIError {
+	return e
+}
+
+// asAPIError finds the APIError in err's chain, whichever typed error wraps it
+func asAPIError(err error) (*APIError, bool) {
+	var target interface{ apiError() *APIError }
+	if errors.As(err, &target) {
+		return target.apiError(), true
+	}
+	return nil, false
+}
+
+// parseRetryAfter reads the retry-after-ms or Retry-After response headers
+func parseRetryAfter(header http.Header, now time.Time) time.Duration {
+	if header == nil {
+		return 0
+	}
+
+	if ms := header.Get("Retry-After-Ms"); ms != "" {
+		if n, err := strconv.ParseFloat(ms, 64); err == nil && n > 0 {
+			return time.Duration(n * float64(time.Millisecond))
+		}
+	}
+
+	value := header.Get("Retry-After")
+	if value == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if at, err := http.ParseTime(value); err == nil && at.After(now) {
+		return at.Sub(now)
+	}
+	return 0
+}
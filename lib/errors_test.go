@@ -0,0 +1,156 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestNewStatusError(t *testing.T) {
+	cause := errors.New("boom")
+
+	tests := []struct {
+		name   string
+		status int
+		check  func(error) bool
+	}{
+		{"rate limit", 429, func(err error) bool { var e *RateLimitError; return errors.As(err, &e) }},
+		{"unauthorized", 401, func(err error) bool { var e *AuthError; return errors.As(err, &e) }},
+		{"forbidden", 403, func(err error) bool { var e *AuthError; return errors.As(err, &e) }},
+		{"server", 503, func(err error) bool { var e *ServerError; return errors.As(err, &e) }},
+		{"bad request", 400, func(err error) bool { var e *APIError; return errors.As(err, &e) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := newStatusError(tt.status, nil, cause)
+			if !tt.check(err) {
+				t.Errorf("Unexpected error type %T for status %d", err, tt.status)
+			}
+			if !errors.Is(err, cause) {
+				t.Error("Expected typed error to wrap the cause")
+			}
+			apiErr, ok := asAPIError(err)
+			if !ok || apiErr.StatusCode != tt.status {
+				t.Errorf("Expected asAPIError to find status %d, got %v", tt.status, apiErr)
+			}
+		})
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2025, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name   string
+		header http.Header
+		want   time.Duration
+	}{
+		{"none", http.Header{}, 0},
+		{"seconds", http.Header{"Retry-After": {"3"}}, 3 * time.Second},
+		{"milliseconds", http.Header{"Retry-After-Ms": {"250"}}, 250 * time.Millisecond},
+		{"http date", http.Header{"Retry-After": {now.Add(5 * time.Second).Format(http.TimeFormat)}}, 5 * time.Second},
+		{"invalid", http.Header{"Retry-After": {"soon"}}, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.header, now); got != tt.want {
+				t.Errorf("parseRetryAfter() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckResponse(t *testing.T) {
+	tests := []struct {
+		name string
+		resp *Response
+		want error
+	}{
+		{"ok", &Response{Content: "hi", FinishReason: "stop"}, nil},
+		{"truncated text", &Response{Content: "hi", FinishReason: "length"}, ErrTruncated},
+		{"empty", &Response{FinishReason: "stop"}, ErrEmptyContent},
+		{"empty truncated", &Response{FinishReason: "length"}, ErrTruncated},
+		{"refusal", &Response{Refusal: "I can't help with that", FinishReason: "stop"}, ErrRefusal},
+		{"content filter", &Response{Content: "partial", FinishReason: "content_filter"}, ErrContentFilter},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := checkResponse("gpt-4o", tt.resp)
+			if tt.want == nil {
+				if err != nil {
+					t.Errorf("Expected no error, got %v", err)
+				}
+				return
+			}
+			if !errors.Is(err, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
+
+func TestResponseError_Fields(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{{ID: "resp-1", Refusal: "No.", FinishReason: "stop"}},
+	}
+
+	_, err := QuickQuery(context.Background(), provider, DefaultConfig(), "Hi", "System")
+
+	var respErr *ResponseError
+	if !errors.As(err, &respErr) {
+		t.Fatalf("Expected ResponseError, got %v", err)
+	}
+	if respErr.Model != "gpt-5-mini" || respErr.ResponseID != "resp-1" || respErr.FinishReason != "stop" || respErr.Refusal != "No." {
+		t.Errorf("Unexpected ResponseError fields: %+v", respErr)
+	}
+	if !errors.Is(err, ErrRefusal) {
+		t.Error("Expected errors.Is to match ErrRefusal")
+	}
+}
+
+func TestTruncatedText(t *testing.T) {
+	truncated := func() *fakeProvider {
+		return &fakeProvider{responses: []*Response{{ID: "1", Content: "The answer is", FinishReason: "length"}}}
+	}
+	check := func(name string, err error) {
+		var respErr *ResponseError
+		if !errors.As(err, &respErr) || !errors.Is(err, ErrTruncated) || respErr.Content != "The answer is" {
+			t.Errorf("%s: expected ErrTruncated with the partial content, got %v", name, err)
+		}
+	}
+
+	_, err := QuickQuery(context.Background(), truncated(), DefaultConfig(), "Hi", "System")
+	check("QuickQuery", err)
+
+	conv := NewConversation(truncated(), DefaultConfig(), "System")
+	_, err = conv.SendMessage(context.Background(), "Hi")
+	check("SendMessage", err)
+	if len(conv.GetHistory()) != 1 {
+		t.Errorf("Expected the truncated turn not to be recorded, got %+v", conv.GetHistory())
+	}
+
+	_, err = QuickQueryStream(context.Background(), truncated(), DefaultConfig(), "Hi", "System").Wait()
+	check("QuickQueryStream", err)
+}
+
+func TestStructuredQuery_TruncatedJSON(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{{ID: "1", Content: `{"answer":"4","sco`, FinishReason: "length"}},
+	}
+
+	config := DefaultConfig()
+	config.TruncationMaxTokens = 0
+
+	var result struct {
+		Answer string `json:"answer"`
+	}
+	err := StructuredQuery(context.Background(), provider, config, "2+2?", "System", &result)
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+}
@@ -0,0 +1,259 @@
+package lib_test
+
+import (
+	"context"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	ai "github.com/bharathcs/go-ai-utils/lib"
+	"github.com/bharathcs/go-ai-utils/lib/testutil"
+)
+
+// The _Integration tests replay recorded API responses from
+// testdata/cassettes. Run them with RECORD_CASSETTES=1 and OPENAI_API_KEY set
+// to record them again. The _FakeServer tests use the scripted fake server.
+
+func TestQuickQueryFromEnv_Integration(t *testing.T) {
+	testutil.UseCassette(t, "quick_query")
+
+	ctx := context.Background()
+	response, err := ai.QuickQueryFromEnv(ctx, "Say 'test' and nothing else", "You are a helpful assistant.")
+
+	if err != nil {
+		t.Fatalf("QuickQueryFromEnv failed: %v", err)
+	}
+
+	if response == "" {
+		t.Error("Expected non-empty response")
+	}
+}
+
+func TestStructuredQueryFromEnv_Integration(t *testing.T) {
+	testutil.UseCassette(t, "structured_query")
+
+	type TestResponse struct {
+		Answer string `json:"answer"`
+		Score  int    `json:"score"`
+	}
+
+	ctx := context.Background()
+	var result TestResponse
+
+	err := ai.StructuredQueryFromEnv(
+		ctx,
+		"What is 2+2? Provide the answer and a confidence score from 1-10.",
+		"You are a math assistant.",
+		&result,
+	)
+
+	if err != nil {
+		t.Fatalf("StructuredQueryFromEnv failed: %v", err)
+	}
+
+	if result.Answer == "" {
+		t.Error("Expected non-empty answer")
+	}
+
+	if result.Score < 1 || result.Score > 10 {
+		t.Errorf("Expected score between 1-10, got %d", result.Score)
+	}
+}
+
+func TestConversation_SendMessage_Integration(t *testing.T) {
+	testutil.UseCassette(t, "conversation_send_message")
+
+	client, config, err := ai.NewClientFromEnv()
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	conv := ai.NewConversation(client, config, "You are a helpful assistant. Keep responses very brief.")
+
+	ctx := context.Background()
+	response, err := conv.SendMessage(ctx, "Say 'hello' and nothing else")
+
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	if response == "" {
+		t.Error("Expected non-empty response")
+	}
+
+	history := conv.GetHistory()
+	if len(history) != 3 {
+		t.Errorf("Expected 3 messages (system, user, assistant), got %d", len(history))
+	}
+
+	if history[1].Role != "user" {
+		t.Errorf("Expected second message to be user, got %s", history[1].Role)
+	}
+
+	if history[2].Role != "assistant" {
+		t.Errorf("Expected third message to be assistant, got %s", history[2].Role)
+	}
+}
+
+func TestConversation_MultiTurn_Integration(t *testing.T) {
+	testutil.UseCassette(t, "conversation_multi_turn")
+
+	client, config, err := ai.NewClientFromEnv()
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	conv := ai.NewConversation(client, config, "You are a helpful assistant. Remember what the user tells you.")
+
+	ctx := context.Background()
+
+	_, err = conv.SendMessage(ctx, "My name is Alice.")
+	if err != nil {
+		t.Fatalf("First message failed: %v", err)
+	}
+
+	response, err := conv.SendMessage(ctx, "What is my name?")
+	if err != nil {
+		t.Fatalf("Second message failed: %v", err)
+	}
+
+	if response == "" {
+		t.Error("Expected non-empty response")
+	}
+
+	history := conv.GetHistory()
+	if len(history) != 5 {
+		t.Errorf("Expected 5 messages (system + 2 user + 2 assistant), got %d", len(history))
+	}
+}
+
+func TestConversation_ResetPreservesSystemMessage_Integration(t *testing.T) {
+	testutil.UseCassette(t, "conversation_reset")
+
+	client, config, err := ai.NewClientFromEnv()
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+
+	systemPrompt := "You are a helpful assistant."
+	conv := ai.NewConversation(client, config, systemPrompt)
+
+	ctx := context.Background()
+
+	_, err = conv.SendMessage(ctx, "Hello")
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	if len(conv.GetHistory()) != 3 {
+		t.Errorf("Expected 3 messages before reset, got %d", len(conv.GetHistory()))
+	}
+
+	conv.Reset()
+
+	history := conv.GetHistory()
+	if len(history) != 1 {
+		t.Errorf("Expected 1 message after reset, got %d", len(history))
+	}
+
+	if history[0].Role != "system" || history[0].Content != systemPrompt {
+		t.Error("System message not preserved after reset")
+	}
+
+	_, err = conv.SendMessage(ctx, "New conversation")
+	if err != nil {
+		t.Fatalf("SendMessage after reset failed: %v", err)
+	}
+
+	if len(conv.GetHistory()) != 3 {
+		t.Errorf("Expected 3 messages after new message, got %d", len(conv.GetHistory()))
+	}
+}
+
+func TestQuickQueryStream_FakeServer(t *testing.T) {
+	fake := testutil.NewFakeServer(t)
+	fake.Enqueue(testutil.FakeResponse{Content: "one two three", Usage: ai.Usage{PromptTokens: 10, CompletionTokens: 3, TotalTokens: 13}})
+
+	stream := ai.QuickQueryStream(context.Background(), fake.Provider(), ai.DefaultConfig(), "Count to three", "")
+	var deltas []string
+	for delta := range stream.Deltas() {
+		deltas = append(deltas, delta)
+	}
+	resp, err := stream.Wait()
+	if err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	if len(deltas) != 3 || strings.Join(deltas, "") != "one two three" {
+		t.Errorf("Expected three deltas, got %q", deltas)
+	}
+	if resp.Content != "one two three" || resp.Usage.TotalTokens != 13 {
+		t.Errorf("Unexpected final response: %+v", resp)
+	}
+	if !fake.Requests()[0].Stream {
+		t.Error("Expected a streaming request")
+	}
+}
+
+func TestConversation_Tools_FakeServer(t *testing.T) {
+	type addArgs struct {
+		A int `json:"a"`
+		B int `json:"b"`
+	}
+
+	tools := ai.NewToolRegistry()
+	err := ai.RegisterTool(tools, "add", "Add two numbers", func(ctx context.Context, args addArgs) (int, error) {
+		return args.A + args.B, nil
+	})
+	if err != nil {
+		t.Fatalf("Failed to register tool: %v", err)
+	}
+
+	fake := testutil.NewFakeServer(t)
+	fake.Enqueue(
+		testutil.FakeResponse{ToolCalls: []ai.ToolCall{{ID: "call_1", Name: "add", Arguments: `{"a":2,"b":3}`}}},
+		testutil.FakeResponse{Content: "2 + 3 = 5"},
+	)
+
+	conv := ai.NewConversation(fake.Provider(), ai.DefaultConfig(), "You can add.")
+	conv.SetTools(tools)
+	answer, err := conv.SendMessage(context.Background(), "What is 2 + 3?")
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if answer != "2 + 3 = 5" {
+		t.Errorf("Expected the final answer, got %q", answer)
+	}
+
+	requests := fake.Requests()
+	if len(requests) != 2 || len(requests[0].Tools) != 1 || requests[0].Tools[0].Name != "add" {
+		t.Fatalf("Expected the tool to be offered, got %d requests", len(requests))
+	}
+	result := requests[1].Messages[len(requests[1].Messages)-1]
+	if result.Role != "tool" || result.ToolCallID != "call_1" || result.Content != "5" {
+		t.Errorf("Expected the tool result to be sent back, got %+v", result)
+	}
+}
+
+func TestRetryAfter_FakeServer(t *testing.T) {
+	fake := testutil.NewFakeServer(t)
+	fake.Enqueue(
+		testutil.FakeResponse{Status: http.StatusTooManyRequests, RetryAfter: 20 * time.Millisecond},
+		testutil.FakeResponse{Content: "ok"},
+	)
+
+	config := ai.DefaultConfig()
+	config.Retry.BaseDelay = time.Minute
+	start := time.Now()
+	answer, err := ai.QuickQuery(context.Background(), fake.Provider(), config, "hi", "")
+	if err != nil || answer != "ok" {
+		t.Fatalf("Expected the retry to succeed, got %q, %v", answer, err)
+	}
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > 10*time.Second {
+		t.Errorf("Expected to wait for Retry-After rather than the backoff, took %v", elapsed)
+	}
+	if len(fake.Requests()) != 2 {
+		t.Errorf("Expected 2 requests, got %d", len(fake.Requests()))
+	}
+}
@@ -0,0 +1,143 @@
+package lib
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+	"time"
+)
+
+// LevelTrace is below slog.LevelDebug. Config.Logger logs prompt and response
+// bodies at this level, with secrets redacted.
+const LevelTrace = slog.Level(-8)
+
+type correlationIDKey struct{}
+
+// WithCorrelationID returns a context whose log lines carry id, tying
+// together everything logged for one operation
+func WithCorrelationID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, correlationIDKey{}, id)
+}
+
+// CorrelationID returns the correlation ID set on ctx, or ""
+func CorrelationID(ctx context.Context) string {
+	id, _ := ctx.Value(correlationIDKey{}).(string)
+	return id
+}
+
+// NewCorrelationID returns a random 16-character hex ID
+func NewCorrelationID() string {
+	var b [8]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// ensureCorrelationID returns ctx with a new correlation ID if it has none
+func ensureCorrelationID(ctx context.Context) context.Context {
+	if CorrelationID(ctx) != "" {
+		return ctx
+	}
+	return WithCorrelationID(ctx, NewCorrelationID())
+}
+
+// contextLogger returns logger with ctx's correlation ID attached, or nil if
+// logger is nil
+func contextLogger(ctx context.Context, logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return nil
+	}
+	if id := CorrelationID(ctx); id != "" {
+		return logger.With("correlation_id", id)
+	}
+	return logger
+}
+
+// logAttemptFailure logs one failed attempt at a request
+func logAttemptFailure(ctx context.Context, config *Config, req *Request, attempt int, err error) {
+	logger := contextLogger(ctx, config.Logger)
+	if logger == nil {
+		return
+	}
+	logger.DebugContext(ctx, "ai request attempt failed", "model", req.Model, "attempt", attempt, "error", redactSecrets(err.Error()))
+}
+
+// logCompletion logs the outcome of a request: its metadata at debug level,
+// and the messages and response content at trace level
+func logCompletion(ctx context.Context, config *Config, req *Request, resp *Response, err error, attempts int, latency time.Duration) {
+	logger := contextLogger(ctx, config.Logger)
+	if logger == nil {
+		return
+	}
+
+	attrs := []any{"model", req.Model, "latency", latency, "attempts", attempts}
+	if err != nil {
+		logger.DebugContext(ctx, "ai request failed", append(attrs, "error", redactSecrets(err.Error()))...)
+	} else {
+		attrs = append(attrs,
+			"response_id", resp.ID,
+			"finish_reason", resp.FinishReason,
+			"prompt_tokens", resp.Usage.PromptTokens,
+			"completion_tokens", resp.Usage.CompletionTokens,
+			"total_tokens", resp.Usage.TotalTokens,
+			"cached", resp.Cached,
+		)
+		logger.DebugContext(ctx, "ai request completed", attrs...)
+	}
+
+	if !logger.Enabled(ctx, LevelTrace) {
+		return
+	}
+	bodies := []any{"model", req.Model, "messages", traceMessages(req.Messages)}
+	if resp != nil {
+		bodies = append(bodies, "content", redactSecrets(resp.Content))
+		if resp.Refusal != "" {
+			bodies = append(bodies, "refusal", redactSecrets(resp.Refusal))
+		}
+		for _, call := range resp.ToolCalls {
+			bodies = append(bodies, "tool_call", call.Name+" "+redactSecrets(call.Arguments))
+		}
+	}
+	logger.Log(ctx, LevelTrace, "ai request bodies", bodies...)
+}
+
+// tracedMessage is the logged form of a Message. Attachments are listed by
+// type rather than logged, since they may be large files.
+type tracedMessage struct {
+	Role        string   `json:"role"`
+	Content     string   `json:"content"`
+	Attachments []string `json:"attachments,omitempty"`
+}
+
+func traceMessages(messages []Message) []tracedMessage {
+	traced := make([]tracedMessage, len(messages))
+	for i, msg := range messages {
+		traced[i] = tracedMessage{Role: msg.Role, Content: redactSecrets(msg.Content)}
+		for _, part := range msg.Parts {
+			traced[i].Attachments = append(traced[i].Attachments, string(part.Type))
+		}
+	}
+	return traced
+}
+
+var secretPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// OpenAI and similar API keys
+	{regexp.MustCompile(`\bsk-[A-Za-z0-9_-]{16,}`), "[REDACTED]"},
+	// Authorization header values
+	{regexp.MustCompile(`(?i)\bbearer\s+[A-Za-z0-9._~+/=-]+`), "Bearer [REDACTED]"},
+	// key=value and "key": "value" pairs with a secret-looking key
+	{regexp.MustCompile(`(?i)((?:api[_-]?key|password|passwd|secret|token)["']?\s*[:=]\s*["']?)[^\s"',;]+`), "${1}[REDACTED]"},
+}
+
+// redactSecrets replaces API keys, bearer tokens and secret-looking
+// key/value pairs in s with [REDACTED]
+func redactSecrets(s string) string {
+	for _, secret := range secretPatterns {
+		s = secret.pattern.ReplaceAllString(s, secret.replacement)
+	}
+	return s
+}
@@ -0,0 +1,55 @@
+package lib
+
+import "context"
+
+// Handler sends one completion request and returns its response
+type Handler func(ctx context.Context, req *Request) (*Response, error)
+
+// Middleware wraps a Handler to observe or change requests and responses.
+// It may modify the request before calling next, inspect or replace the
+// response, or return without calling next at all.
+type Middleware func(next Handler) Handler
+
+type middlewareKey struct{}
+
+// WithMiddleware returns a context whose calls also run through middleware,
+// outside any set on Config. Use it to wrap calls whose config you don't
+// control, such as QuickQueryFromEnv.
+func WithMiddleware(ctx context.Context, middleware ...Middleware) context.Context {
+	existing, _ := ctx.Value(middlewareKey{}).([]Middleware)
+	combined := append(append([]Middleware(nil), existing...), middleware...)
+	return context.WithValue(ctx, middlewareKey{}, combined)
+}
+
+// Hooks creates a Middleware calling before ahead of each request and after
+// once it completes. If before returns an error the request is not sent and
+// after is not called. Either hook may be nil.
+func Hooks(before func(ctx context.Context, req *Request) error, after func(ctx context.Context, req *Request, resp *Response, err error)) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			if before != nil {
+				if err := before(ctx, req); err != nil {
+					return nil, err
+				}
+			}
+			resp, err := next(ctx, req)
+			if after != nil {
+				after(ctx, req, resp, err)
+			}
+			return resp, err
+		}
+	}
+}
+
+// runMiddleware sends req through the middleware on ctx and config, in that
+// order from the outside in, ending with handler
+func runMiddleware(ctx context.Context, config *Config, req *Request, handler Handler) (*Response, error) {
+	fromCtx, _ := ctx.Value(middlewareKey{}).([]Middleware)
+	for i := len(config.Middleware) - 1; i >= 0; i-- {
+		handler = config.Middleware[i](handler)
+	}
+	for i := len(fromCtx) - 1; i >= 0; i-- {
+		handler = fromCtx[i](handler)
+	}
+	return handler(ctx, req)
+}
@@ -0,0 +1,169 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// tagMiddleware records the order it runs in and marks the response
+func tagMiddleware(name string, order *[]string) Middleware {
+	return func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			*order = append(*order, "before "+name)
+			resp, err := next(ctx, req)
+			*order = append(*order, "after "+name)
+			if resp != nil {
+				resp.ID += "+" + name
+			}
+			return resp, err
+		}
+	}
+}
+
+func TestMiddleware_Order(t *testing.T) {
+	var order []string
+	config := DefaultConfig()
+	config.Middleware = []Middleware{tagMiddleware("config1", &order), tagMiddleware("config2", &order)}
+	ctx := WithMiddleware(context.Background(), tagMiddleware("ctx", &order))
+
+	provider := &fakeProvider{responses: []*Response{{ID: "resp", Content: "ok", FinishReason: "stop"}}}
+	resp, err := complete(ctx, provider, config, newRequest(config, []Message{{Role: "user", Content: "hi"}}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	expected := "before ctx,before config1,before config2,after config2,after config1,after ctx"
+	if got := strings.Join(order, ","); got != expected {
+		t.Errorf("Expected order %q, got %q", expected, got)
+	}
+	if resp.ID != "resp+config2+config1+ctx" {
+		t.Errorf("Expected each middleware to see the response, got %q", resp.ID)
+	}
+}
+
+func TestMiddleware_AltersRequest(t *testing.T) {
+	redact := func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			redacted := *req
+			redacted.Messages = copyMessages(req.Messages)
+			for i := range redacted.Messages {
+				redacted.Messages[i].Content = strings.ReplaceAll(redacted.Messages[i].Content, "secret", "[redacted]")
+			}
+			return next(ctx, &redacted)
+		}
+	}
+
+	provider := &fakeProvider{}
+	config := DefaultConfig()
+	config.Middleware = []Middleware{redact}
+	conv := NewConversation(provider, config, "System")
+
+	if _, err := conv.SendMessage(context.Background(), "my secret is 42"); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if got := provider.requests[0].Messages[1].Content; got != "my [redacted] is 42" {
+		t.Errorf("Expected the provider to see the redacted message, got %q", got)
+	}
+	if got := conv.GetHistory()[1].Content; got != "my secret is 42" {
+		t.Errorf("Expected the conversation to keep the original message, got %q", got)
+	}
+}
+
+func TestMiddleware_ShortCircuit(t *testing.T) {
+	errBlocked := errors.New("blocked by guardrail")
+	guardrail := Hooks(func(ctx context.Context, req *Request) error {
+		if strings.Contains(req.Messages[len(req.Messages)-1].Content, "forbidden") {
+			return errBlocked
+		}
+		return nil
+	}, nil)
+	canned := func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			return &Response{ID: "canned", Content: "from middleware", FinishReason: "stop"}, nil
+		}
+	}
+
+	provider := &fakeProvider{}
+	config := DefaultConfig()
+	config.Middleware = []Middleware{guardrail, canned}
+
+	_, err := QuickQuery(context.Background(), provider, config, "something forbidden", "")
+	if !errors.Is(err, errBlocked) {
+		t.Errorf("Expected the guardrail error, got %v", err)
+	}
+
+	stream := QuickQueryStream(context.Background(), provider, config, "hello", "")
+	var deltas []string
+	for delta := range stream.Deltas() {
+		deltas = append(deltas, delta)
+	}
+	resp, err := stream.Wait()
+	if err != nil || resp.Content != "from middleware" || len(deltas) != 1 || deltas[0] != "from middleware" {
+		t.Errorf("Expected the canned response as one delta, got %q, %+v, %v", deltas, resp, err)
+	}
+
+	if len(provider.requests) != 0 {
+		t.Errorf("Expected no provider calls, got %d", len(provider.requests))
+	}
+}
+
+func TestHooks(t *testing.T) {
+	var seenReq *Request
+	var seenResp *Response
+	var seenErr error
+	hooks := Hooks(nil, func(ctx context.Context, req *Request, resp *Response, err error) {
+		seenReq, seenResp, seenErr = req, resp, err
+	})
+
+	config := DefaultConfig()
+	config.Retry = RetryPolicy{MaxAttempts: 1}
+	ctx := WithMiddleware(context.Background(), hooks)
+
+	var target structuredAnswer
+	provider := &fakeProvider{responses: []*Response{{ID: "1", Content: `{"answer":"4","score":9}`, FinishReason: "stop", Usage: Usage{TotalTokens: 3}}}}
+	if err := StructuredQuery(ctx, provider, config, "2+2?", "Math", &target); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if seenReq == nil || seenReq.Schema == nil || seenResp == nil || seenResp.Usage.TotalTokens != 3 || seenErr != nil {
+		t.Errorf("Expected the after hook to see the structured request and response, got %+v, %+v, %v", seenReq, seenResp, seenErr)
+	}
+
+	failing := &fakeProvider{err: errors.New("boom")}
+	QuickQuery(ctx, failing, config, "hi", "")
+	if seenErr == nil || seenErr.Error() != "boom" || seenResp != nil {
+		t.Errorf("Expected the after hook to see the error, got %+v, %v", seenResp, seenErr)
+	}
+}
+
+func TestMiddleware_SchemaChangesStayLocal(t *testing.T) {
+	rename := func(next Handler) Handler {
+		return func(ctx context.Context, req *Request) (*Response, error) {
+			req.Schema.Name = "renamed"
+			delete(req.Schema.Schema["properties"].(map[string]interface{}), "score")
+			return next(ctx, req)
+		}
+	}
+
+	provider := &fakeProvider{responses: []*Response{
+		{ID: "1", Content: `{"answer":"4","score":9}`, FinishReason: "stop"},
+		{ID: "2", Content: `{"answer":"4","score":9}`, FinishReason: "stop"},
+	}}
+	config := DefaultConfig()
+	var target structuredAnswer
+	if err := StructuredQuery(WithMiddleware(context.Background(), rename), provider, config, "2+2?", "Math", &target); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if err := StructuredQuery(context.Background(), provider, config, "2+2?", "Math", &target); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if got := provider.requests[0].Schema.Name; got != "renamed" {
+		t.Errorf("Expected the middleware to change the first request, got %q", got)
+	}
+	schema := provider.requests[1].Schema
+	if _, ok := schema.Schema["properties"].(map[string]interface{})["score"]; schema.Name != "structuredanswer" || !ok {
+		t.Errorf("Expected the second request to send the original schema, got %+v", schema)
+	}
+}
@@ -0,0 +1,130 @@
+package lib
+
+import (
+	"strings"
+	"sync"
+)
+
+// TokenParam is the request parameter a model uses to limit output tokens
+type TokenParam string
+
+const (
+	// TokenParamMaxTokens is the legacy max_tokens parameter
+	TokenParamMaxTokens TokenParam = "max_tokens"
+	// TokenParamMaxCompletionTokens is max_completion_tokens, required by reasoning models
+	TokenParamMaxCompletionTokens TokenParam = "max_completion_tokens"
+)
+
+// ModelInfo describes the capabilities of a model
+type ModelInfo struct {
+	// Name is the model name, or a prefix matching a family of models
+	Name       string
+	TokenParam TokenParam
+	// SupportsTemperature is false for reasoning models, which reject temperature
+	SupportsTemperature       bool
+	SupportsStructuredOutputs bool
+	SupportsTools             bool
+	// SupportsReasoningEffort reports whether reasoning_effort is accepted
+	SupportsReasoningEffort bool
+	// ContextWindow is the maximum number of input and output tokens, or 0 if unknown
+	ContextWindow int
+}
+
+// reasoningModel returns the capabilities shared by OpenAI reasoning models
+func reasoningModel(name string, contextWindow int) ModelInfo {
+	return ModelInfo{
+		Name:                      name,
+		TokenParam:                TokenParamMaxCompletionTokens,
+		SupportsStructuredOutputs: true,
+		SupportsTools:             true,
+		SupportsReasoningEffort:   true,
+		ContextWindow:             contextWindow,
+	}
+}
+
+// chatModel returns the capabilities shared by OpenAI non-reasoning chat models
+func chatModel(name string, contextWindow int, structured bool) ModelInfo {
+	return ModelInfo{
+		Name:                      name,
+		TokenParam:                TokenParamMaxTokens,
+		SupportsTemperature:       true,
+		SupportsStructuredOutputs: structured,
+		SupportsTools:             true,
+		ContextWindow:             contextWindow,
+	}
+}
+
+// defaultModels lists the built-in models. Entries are matched by longest
+// prefix, so "gpt-4o" also covers dated snapshots like "gpt-4o-2024-08-06".
+var defaultModels = []ModelInfo{
+	chatModel("gpt-3.5-turbo", 16385, false),
+	chatModel("gpt-4", 8192, false),
+	chatModel("gpt-4-turbo", 128000, false),
+	chatModel("gpt-4o", 128000, true),
+	chatModel("gpt-4o-mini", 128000, true),
+	chatModel("gpt-4.1", 1047576, true),
+	reasoningModel("o1", 200000),
+	{Name: "o1-mini", TokenParam: TokenParamMaxCompletionTokens, ContextWindow: 128000},
+	reasoningModel("o3", 200000),
+	reasoningModel("o3-mini", 200000),
+	reasoningModel("o4-mini", 200000),
+	reasoningModel("gpt-5", 400000),
+}
+
+// unknownModel is used for models missing from the registry. It keeps the
+// legacy max_tokens parameter, which OpenAI-compatible servers widely accept,
+// and assumes every feature is supported so requests aren't blocked.
+var unknownModel = ModelInfo{
+	TokenParam:                TokenParamMaxTokens,
+	SupportsTemperature:       true,
+	SupportsStructuredOutputs: true,
+	SupportsTools:             true,
+}
+
+// modelRegistry holds model capabilities keyed by name or prefix
+type modelRegistry struct {
+	mu     sync.RWMutex
+	models map[string]ModelInfo
+}
+
+var models = newModelRegistry(defaultModels)
+
+func newModelRegistry(infos []ModelInfo) *modelRegistry {
+	r := &modelRegistry{models: make(map[string]ModelInfo, len(infos))}
+	for _, info := range infos {
+		r.models[info.Name] = info
+	}
+	return r
+}
+
+// RegisterModel adds or replaces the capabilities for info.Name. The name
+// also acts as a prefix for models without a more specific entry.
+func RegisterModel(info ModelInfo) {
+	models.mu.Lock()
+	defer models.mu.Unlock()
+	models.models[info.Name] = info
+}
+
+// LookupModel returns the capabilities for model, matching exactly or by
+// longest prefix. For unknown models it returns permissive defaults and false.
+func LookupModel(model string) (ModelInfo, bool) {
+	models.mu.RLock()
+	defer models.mu.RUnlock()
+
+	if info, ok := models.models[model]; ok {
+		return info, true
+	}
+
+	best := ""
+	for name := range models.models {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		info := unknownModel
+		info.Name = model
+		return info, false
+	}
+	return models.models[best], true
+}
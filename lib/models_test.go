@@ -0,0 +1,118 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+func TestLookupModel(t *testing.T) {
+	tests := []struct {
+		model       string
+		tokenParam  TokenParam
+		temperature bool
+		known       bool
+	}{
+		{"gpt-5-mini", TokenParamMaxCompletionTokens, false, true},
+		{"gpt-5-nano", TokenParamMaxCompletionTokens, false, true},
+		{"gpt-5", TokenParamMaxCompletionTokens, false, true},
+		{"o3-mini", TokenParamMaxCompletionTokens, false, true},
+		{"o4-mini-2025-04-16", TokenParamMaxCompletionTokens, false, true},
+		{"gpt-4o", TokenParamMaxTokens, true, true},
+		{"gpt-4o-2024-08-06", TokenParamMaxTokens, true, true},
+		{"gpt-3.5-turbo", TokenParamMaxTokens, true, true},
+		{"llama3", TokenParamMaxTokens, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			info, known := LookupModel(tt.model)
+			if known != tt.known {
+				t.Errorf("Expected known %v, got %v", tt.known, known)
+			}
+			if info.TokenParam != tt.tokenParam {
+				t.Errorf("Expected token param %s, got %s", tt.tokenParam, info.TokenParam)
+			}
+			if info.SupportsTemperature != tt.temperature {
+				t.Errorf("Expected SupportsTemperature %v, got %v", tt.temperature, info.SupportsTemperature)
+			}
+		})
+	}
+}
+
+func TestLookupModel_LongestPrefix(t *testing.T) {
+	info, _ := LookupModel("gpt-4o-mini-2024-07-18")
+	if info.Name != "gpt-4o-mini" {
+		t.Errorf("Expected gpt-4o-mini entry, got %s", info.Name)
+	}
+}
+
+func TestRegisterModel(t *testing.T) {
+	RegisterModel(ModelInfo{Name: "test-reasoner", TokenParam: TokenParamMaxCompletionTokens, ContextWindow: 1234})
+	defer func() {
+		models.mu.Lock()
+		delete(models.models, "test-reasoner")
+		models.mu.Unlock()
+	}()
+
+	info, known := LookupModel("test-reasoner-v2")
+	if !known || info.ContextWindow != 1234 {
+		t.Errorf("Expected registered model, got %+v (known %v)", info, known)
+	}
+
+	params := createChatCompletionParams(&Config{Model: "test-reasoner-v2", MaxTokens: 50}, nil)
+	if !params.MaxCompletionTokens.Valid() || params.MaxTokens.Valid() {
+		t.Error("Expected max_completion_tokens for a registered reasoning model")
+	}
+}
+
+func TestCreateChatCompletionParams_TokenParam(t *testing.T) {
+	tests := []struct {
+		model          string
+		wantCompletion bool
+	}{
+		{"gpt-5-mini", true},
+		{"gpt-5-nano", true},
+		{"o3", true},
+		{"gpt-4o", false},
+		{"custom-model", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			params := createChatCompletionParams(&Config{Model: tt.model, MaxTokens: 500}, nil)
+			if params.MaxCompletionTokens.Valid() != tt.wantCompletion || params.MaxTokens.Valid() == tt.wantCompletion {
+				t.Errorf("Expected max_completion_tokens %v, got params %+v", tt.wantCompletion, params)
+			}
+		})
+	}
+}
+
+func TestCreateChatCompletionParams_Capabilities(t *testing.T) {
+	temperature := 0.2
+
+	params := createChatCompletionParams(&Config{Model: "gpt-4o", Temperature: &temperature, ReasoningEffort: "low"}, nil)
+	if !params.Temperature.Valid() || params.ReasoningEffort != "" {
+		t.Error("Expected gpt-4o to get temperature but not reasoning_effort")
+	}
+
+	params = createChatCompletionParams(&Config{Model: "gpt-5-mini", Temperature: &temperature, ReasoningEffort: "low"}, nil)
+	if params.Temperature.Valid() || params.ReasoningEffort != "low" {
+		t.Error("Expected gpt-5-mini to get reasoning_effort but not temperature")
+	}
+}
+
+func TestStructuredQuery_UnsupportedModel(t *testing.T) {
+	provider := &fakeProvider{}
+	config := DefaultConfig()
+	config.Model = "gpt-3.5-turbo"
+
+	var result structuredAnswer
+	err := StructuredQuery(context.Background(), provider, config, "2+2?", "", &result)
+	if err == nil || !strings.Contains(err.Error(), "does not support structured outputs") {
+		t.Errorf("Expected unsupported model error, got %v", err)
+	}
+	if len(provider.requests) != 0 {
+		t.Errorf("Expected no requests, got %d", len(provider.requests))
+	}
+}
@@ -0,0 +1,106 @@
+package lib
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// conversationVersion is the current version of the saved conversation format
+const conversationVersion = 1
+
+// savedConversation is the JSON form of a Conversation. Only the messages
+// sent to the provider are stored; the history is derived from them on load.
+type savedConversation struct {
+	Version int    `json:"version"`
+	Model   string `json:"model"`
+	// SystemPrompt is nil for conversations without a system message
+	SystemPrompt *string   `json:"system_prompt,omitempty"`
+	Messages     []Message `json:"messages"`
+	Usage        Usage     `json:"usage"`
+}
+
+// MarshalJSON encodes the conversation's system prompt, turns (including tool
+// calls and results), model and usage. The client and tools are not saved.
+func (c *Conversation) MarshalJSON() ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	saved := savedConversation{
+		Version:  conversationVersion,
+		Model:    c.config.Model,
+		Messages: c.messages,
+		Usage:    c.usage,
+	}
+	if len(c.messages) > 0 && c.messages[0].Role == "system" {
+		saved.SystemPrompt = &c.messages[0].Content
+		saved.Messages = c.messages[1:]
+	}
+	if saved.Messages == nil {
+		saved.Messages = []Message{}
+	}
+
+	return json.Marshal(saved)
+}
+
+// Save writes the conversation to w as JSON
+func (c *Conversation) Save(w io.Writer) error {
+	data, err := c.MarshalJSON()
+	if err != nil {
+		return fmt.Errorf("failed to encode conversation: %w", err)
+	}
+
+	_, err = w.Write(data)
+	return err
+}
+
+// LoadConversation restores a conversation written by Save. config (or
+// DefaultConfig if nil) supplies the settings for future turns; its model is
+// replaced by the saved one. Tools are not saved, so call SetTools again if
+// the conversation uses them.
+func LoadConversation(r io.Reader, client Provider, config *Config) (*Conversation, error) {
+	var saved savedConversation
+	if err := json.NewDecoder(r).Decode(&saved); err != nil {
+		return nil, fmt.Errorf("failed to decode conversation: %w", err)
+	}
+
+	if saved.Version < 1 || saved.Version > conversationVersion {
+		return nil, fmt.Errorf("unsupported conversation version %d (supported: %d)", saved.Version, conversationVersion)
+	}
+
+	if config == nil {
+		config = DefaultConfig()
+	}
+	restored := *config
+	if saved.Model != "" {
+		restored.Model = saved.Model
+	}
+
+	c := &Conversation{
+		busy:     make(chan struct{}, 1),
+		client:   client,
+		config:   &restored,
+		messages: []Message{},
+		history:  []Message{},
+		usage:    saved.Usage,
+	}
+	if saved.SystemPrompt != nil {
+		systemMessage := Message{Role: "system", Content: *saved.SystemPrompt}
+		c.messages = append(c.messages, systemMessage)
+		c.history = append(c.history, systemMessage)
+	}
+
+	for i, msg := range saved.Messages {
+		switch {
+		case msg.Role == "user", msg.Role == "assistant" && len(msg.ToolCalls) == 0:
+			c.history = append(c.history, msg)
+		case msg.Role == "assistant", msg.Role == "tool":
+			// Tool exchanges are only part of the provider context
+		default:
+			return nil, fmt.Errorf("invalid role %q in saved message %d", msg.Role, i)
+		}
+		c.messages = append(c.messages, msg)
+	}
+
+	return c, nil
+}
@@ -0,0 +1,120 @@
+package lib
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestConversation_SaveLoad(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{
+			{ID: "1", FinishReason: "tool_calls", ToolCalls: []ToolCall{{ID: "call_1", Name: "add", Arguments: `{"a":2,"b":3}`}}, Usage: Usage{TotalTokens: 10}},
+			{ID: "2", Content: "5", FinishReason: "stop", Usage: Usage{TotalTokens: 20}},
+			{ID: "3", Content: "You're welcome", FinishReason: "stop", Usage: Usage{TotalTokens: 5}},
+		},
+	}
+	config := DefaultConfig()
+	config.Model = "gpt-4o"
+	conv := NewConversation(provider, config, "System")
+	conv.SetTools(newAddRegistry(t))
+
+	ctx := context.Background()
+	for _, msg := range []string{"2+3?", "Thanks"} {
+		if _, err := conv.SendMessage(ctx, msg); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := conv.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+
+	loaded, err := LoadConversation(&buf, provider, DefaultConfig())
+	if err != nil {
+		t.Fatalf("LoadConversation failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(loaded.messages, conv.messages) {
+		t.Errorf("Expected messages %+v, got %+v", conv.messages, loaded.messages)
+	}
+	if !reflect.DeepEqual(loaded.GetHistory(), conv.GetHistory()) {
+		t.Errorf("Expected history %+v, got %+v", conv.GetHistory(), loaded.GetHistory())
+	}
+	if loaded.config.Model != "gpt-4o" {
+		t.Errorf("Expected saved model gpt-4o, got %s", loaded.config.Model)
+	}
+	if loaded.Usage() != conv.Usage() {
+		t.Errorf("Expected usage %+v, got %+v", conv.Usage(), loaded.Usage())
+	}
+}
+
+func TestConversation_MarshalJSON(t *testing.T) {
+	conv := NewConversation(&fakeProvider{}, DefaultConfig(), "System")
+	if _, err := conv.SendMessage(context.Background(), "Hello"); err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+
+	data, err := json.Marshal(conv)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	var saved map[string]interface{}
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+	if saved["version"] != float64(1) || saved["model"] != "gpt-5-mini" || saved["system_prompt"] != "System" {
+		t.Errorf("Unexpected saved conversation: %s", data)
+	}
+	if messages := saved["messages"].([]interface{}); len(messages) != 2 {
+		t.Errorf("Expected 2 saved messages, got %d", len(messages))
+	}
+}
+
+func TestConversation_SaveLoad_WithoutSystemMessage(t *testing.T) {
+	conv := &Conversation{
+		config:   DefaultConfig(),
+		messages: []Message{{Role: "user", Content: "Hi"}, {Role: "assistant", Content: "Hello"}},
+		history:  []Message{{Role: "user", Content: "Hi"}, {Role: "assistant", Content: "Hello"}},
+	}
+
+	var buf bytes.Buffer
+	if err := conv.Save(&buf); err != nil {
+		t.Fatalf("Save failed: %v", err)
+	}
+	loaded, err := LoadConversation(&buf, &fakeProvider{}, nil)
+	if err != nil {
+		t.Fatalf("LoadConversation failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(loaded.GetHistory(), conv.history) {
+		t.Errorf("Expected history %+v, got %+v", conv.history, loaded.GetHistory())
+	}
+}
+
+func TestLoadConversation_Invalid(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"malformed", `{`, "failed to decode conversation"},
+		{"missing version", `{"messages":[]}`, "unsupported conversation version 0"},
+		{"future version", `{"version":99,"messages":[]}`, "unsupported conversation version 99"},
+		{"bad role", `{"version":1,"messages":[{"role":"system","content":"x"}]}`, `invalid role "system"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := LoadConversation(strings.NewReader(tt.data), &fakeProvider{}, nil)
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error containing %q, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,265 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+	"github.com/openai/openai-go/shared"
+)
+
+// Provider is a chat completion backend. Implementations handle both plain
+// chat completions and structured completions (when Request.Schema is set).
+type Provider interface {
+	Complete(ctx context.Context, req *Request) (*Response, error)
+}
+
+// Request describes a single provider-agnostic chat completion call
+type Request struct {
+	Model           string
+	Messages        []Message
+	MaxTokens       int
+	Temperature     *float64
+	ReasoningEffort string
+	// Schema, if set, asks the provider for a response conforming to the JSON schema
+	Schema *ResponseSchema
+	// Tools the model may call instead of answering directly
+	Tools []ToolDefinition
+}
+
+// ResponseSchema describes a JSON schema response format for structured completions
+type ResponseSchema struct {
+	Name   string
+	Schema map[string]interface{}
+	Strict bool
+}
+
+// Response is the provider-agnostic result of a chat completion call
+type Response struct {
+	ID           string
+	Model        string
+	Content      string
+	Refusal      string
+	FinishReason string
+	// ToolCalls holds the tools the model asked to run, if any
+	ToolCalls []ToolCall
+	Usage     Usage
+	// Cached is set when the response came from Config.Cache
+	Cached bool
+}
+
+// newRequest creates a request for the given messages using the config's model settings
+func newRequest(config *Config, messages []Message) *Request {
+	return &Request{
+		Model:           config.Model,
+		Messages:        messages,
+		MaxTokens:       config.MaxTokens,
+		Temperature:     config.Temperature,
+		ReasoningEffort: config.ReasoningEffort,
+	}
+}
+
+// OpenAIProvider implements Provider using the openai-go SDK
+type OpenAIProvider struct {
+	client *openai.Client
+}
+
+// noSDKRetries disables the SDK's own retries on a request, whatever the
+// client was built with, so Config.Retry and BatchClient.Retry are the only
+// retry layer
+var noSDKRetries = option.WithMaxRetries(0)
+
+// NewOpenAIProvider wraps an existing OpenAI client as a Provider. Failed
+// requests are retried according to Config.Retry, not the client's settings.
+func NewOpenAIProvider(client *openai.Client) *OpenAIProvider {
+	return &OpenAIProvider{client: client}
+}
+
+// Complete sends the request to the OpenAI chat completions API
+func (p *OpenAIProvider) Complete(ctx context.Context, req *Request) (*Response, error) {
+	resp, err := p.client.Chat.Completions.New(ctx, buildOpenAIParams(req), noSDKRetries)
+	if err != nil {
+		return nil, fromOpenAIError(err)
+	}
+
+	return fromOpenAICompletion(req, resp)
+}
+
+// CompleteStream sends the request to the OpenAI chat completions API with
+// streaming enabled, calling onDelta for each content fragment as it arrives
+func (p *OpenAIProvider) CompleteStream(ctx context.Context, req *Request, onDelta func(string)) (*Response, error) {
+	params := buildOpenAIParams(req)
+	params.StreamOptions = openai.ChatCompletionStreamOptionsParam{IncludeUsage: openai.Bool(true)}
+
+	stream := p.client.Chat.Completions.NewStreaming(ctx, params, noSDKRetries)
+	defer stream.Close()
+
+	acc := openai.ChatCompletionAccumulator{}
+	var usage openai.CompletionUsage
+	for stream.Next() {
+		chunk := stream.Current()
+		acc.AddChunk(chunk)
+		if len(chunk.Choices) > 0 && chunk.Choices[0].Delta.Content != "" {
+			onDelta(chunk.Choices[0].Delta.Content)
+		}
+		// The accumulator drops token details, so keep the final usage chunk
+		if chunk.Usage.TotalTokens > 0 {
+			usage = chunk.Usage
+		}
+	}
+	if err := stream.Err(); err != nil {
+		return nil, fromOpenAIError(err)
+	}
+
+	acc.ChatCompletion.Usage = usage
+	return fromOpenAICompletion(req, &acc.ChatCompletion)
+}
+
+// buildOpenAIParams converts a provider-agnostic request to OpenAI params
+func buildOpenAIParams(req *Request) openai.ChatCompletionNewParams {
+	config := &Config{
+		Model:           req.Model,
+		MaxTokens:       req.MaxTokens,
+		Temperature:     req.Temperature,
+		ReasoningEffort: req.ReasoningEffort,
+	}
+	params := createChatCompletionParams(config, toOpenAIMessages(req.Messages))
+
+	if req.Schema != nil {
+		params.ResponseFormat = openai.ChatCompletionNewParamsResponseFormatUnion{
+			OfJSONSchema: &openai.ResponseFormatJSONSchemaParam{
+				JSONSchema: openai.ResponseFormatJSONSchemaJSONSchemaParam{
+					Name:   req.Schema.Name,
+					Schema: req.Schema.Schema,
+					Strict: openai.Bool(req.Schema.Strict),
+				},
+			},
+		}
+	}
+
+	for _, tool := range req.Tools {
+		params.Tools = append(params.Tools, openai.ChatCompletionToolParam{
+			Function: shared.FunctionDefinitionParam{
+				Name:        tool.Name,
+				Description: openai.String(tool.Description),
+				Parameters:  tool.Parameters,
+				Strict:      openai.Bool(true),
+			},
+		})
+	}
+
+	return params
+}
+
+// fromOpenAICompletion converts an OpenAI chat completion to a provider-agnostic response
+func fromOpenAICompletion(req *Request, resp *openai.ChatCompletion) (*Response, error) {
+	if len(resp.Choices) == 0 {
+		return nil, &ResponseError{Err: ErrNoChoices, Model: req.Model, ResponseID: resp.ID}
+	}
+
+	var toolCalls []ToolCall
+	for _, call := range resp.Choices[0].Message.ToolCalls {
+		toolCalls = append(toolCalls, ToolCall{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+	}
+
+	return &Response{
+		ID:           resp.ID,
+		Model:        resp.Model,
+		Content:      resp.Choices[0].Message.Content,
+		Refusal:      resp.Choices[0].Message.Refusal,
+		FinishReason: resp.Choices[0].FinishReason,
+		ToolCalls:    toolCalls,
+		Usage: Usage{
+			PromptTokens:     resp.Usage.PromptTokens,
+			CompletionTokens: resp.Usage.CompletionTokens,
+			TotalTokens:      resp.Usage.TotalTokens,
+			CachedTokens:     resp.Usage.PromptTokensDetails.CachedTokens,
+			ReasoningTokens:  resp.Usage.CompletionTokensDetails.ReasoningTokens,
+		},
+	}, nil
+}
+
+// fromOpenAIError converts OpenAI API errors to the typed lib errors
+func fromOpenAIError(err error) error {
+	var apiErr *openai.Error
+	if errors.As(err, &apiErr) {
+		var header http.Header
+		if apiErr.Response != nil {
+			header = apiErr.Response.Header
+		}
+		return newStatusError(apiErr.StatusCode, header, err)
+	}
+	return err
+}
+
+// toOpenAIMessages converts conversation messages to OpenAI message params
+func toOpenAIMessages(messages []Message) []openai.ChatCompletionMessageParamUnion {
+	result := make([]openai.ChatCompletionMessageParamUnion, 0, len(messages))
+	for _, msg := range messages {
+		switch msg.Role {
+		case "system":
+			result = append(result, openai.SystemMessage(msg.Content))
+		case "assistant":
+			if len(msg.ToolCalls) > 0 {
+				result = append(result, toOpenAIToolCallMessage(msg))
+			} else {
+				result = append(result, openai.AssistantMessage(msg.Content))
+			}
+		case "tool":
+			result = append(result, openai.ToolMessage(msg.Content, msg.ToolCallID))
+		default:
+			if len(msg.Parts) > 0 {
+				result = append(result, openai.UserMessage(toOpenAIContentParts(msg)))
+			} else {
+				result = append(result, openai.UserMessage(msg.Content))
+			}
+		}
+	}
+	return result
+}
+
+// toOpenAIToolCallMessage converts an assistant message that requested tool calls
+func toOpenAIToolCallMessage(msg Message) openai.ChatCompletionMessageParamUnion {
+	assistant := openai.ChatCompletionAssistantMessageParam{}
+	if msg.Content != "" {
+		assistant.Content.OfString = openai.String(msg.Content)
+	}
+	for _, call := range msg.ToolCalls {
+		assistant.ToolCalls = append(assistant.ToolCalls, openai.ChatCompletionMessageToolCallParam{
+			ID: call.ID,
+			Function: openai.ChatCompletionMessageToolCallFunctionParam{
+				Name:      call.Name,
+				Arguments: call.Arguments,
+			},
+		})
+	}
+	return openai.ChatCompletionMessageParamUnion{OfAssistant: &assistant}
+}
+
+// toOpenAIContentParts converts a multimodal user message to OpenAI content parts
+func toOpenAIContentParts(msg Message) []openai.ChatCompletionContentPartUnionParam {
+	var parts []openai.ChatCompletionContentPartUnionParam
+	if msg.Content != "" {
+		parts = append(parts, openai.TextContentPart(msg.Content))
+	}
+	for _, part := range msg.Parts {
+		switch part.Type {
+		case PartImage:
+			parts = append(parts, openai.ImageContentPart(openai.ChatCompletionContentPartImageImageURLParam{
+				URL:    part.URL,
+				Detail: part.Detail,
+			}))
+		case PartFile:
+			file := openai.ChatCompletionContentPartFileFileParam{FileData: openai.String(part.URL)}
+			if part.Filename != "" {
+				file.Filename = openai.String(part.Filename)
+			}
+			parts = append(parts, openai.FileContentPart(file))
+		default:
+			parts = append(parts, openai.TextContentPart(part.Text))
+		}
+	}
+	return parts
+}
@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// fakeProvider is an in-memory Provider that records requests and replays canned responses
+type fakeProvider struct {
+	requests  []*Request
+	responses []*Response
+	err       error
+}
+
+func (f *fakeProvider) Complete(ctx context.Context, req *Request) (*Response, error) {
+	// Copy messages so later appends by the caller don't alter the recorded request
+	recorded := *req
+	recorded.Messages = append([]Message(nil), req.Messages...)
+	f.requests = append(f.requests, &recorded)
+
+	if f.err != nil {
+		return nil, f.err
+	}
+	if len(f.responses) == 0 {
+		return &Response{ID: "fake", Model: req.Model, Content: "ok", FinishReason: "stop"}, nil
+	}
+	resp := f.responses[0]
+	f.responses = f.responses[1:]
+	return resp, nil
+}
+
+func TestToOpenAIMessages(t *testing.T) {
+	messages := []Message{
+		{Role: "system", Content: "sys"},
+		{Role: "user", Content: "hi"},
+		{Role: "assistant", Content: "hello"},
+	}
+
+	result := toOpenAIMessages(messages)
+	if len(result) != 3 {
+		t.Fatalf("Expected 3 messages, got %d", len(result))
+	}
+
+	if result[0].OfSystem == nil {
+		t.Error("Expected first message to be a system message")
+	}
+	if result[1].OfUser == nil {
+		t.Error("Expected second message to be a user message")
+	}
+	if result[2].OfAssistant == nil {
+		t.Error("Expected third message to be an assistant message")
+	}
+}
+
+func TestOpenAIProvider_Complete(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"{\"answer\":\"4\"}"}}]}`)
+	}))
+	defer server.Close()
+
+	client := openai.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL))
+	provider := NewOpenAIProvider(&client)
+
+	resp, err := provider.Complete(context.Background(), &Request{
+		Model:     "gpt-4o",
+		MaxTokens: 100,
+		Messages:  []Message{{Role: "user", Content: "2+2?"}},
+		Schema: &ResponseSchema{
+			Name:   "answer",
+			Schema: map[string]interface{}{"type": "object"},
+			Strict: true,
+		},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if resp.ID != "chatcmpl-1" || resp.Content != `{"answer":"4"}` || resp.FinishReason != "stop" {
+		t.Errorf("Unexpected response: %+v", resp)
+	}
+
+	format, ok := body["response_format"].(map[string]interface{})
+	if !ok || format["type"] != "json_schema" {
+		t.Errorf("Expected json_schema response format, got %v", body["response_format"])
+	}
+}
+
+func TestOpenAIProvider_NoChoices(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"chatcmpl-2","object":"chat.completion","model":"gpt-4o","choices":[]}`)
+	}))
+	defer server.Close()
+
+	client := openai.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL))
+	provider := NewOpenAIProvider(&client)
+
+	_, err := provider.Complete(context.Background(), &Request{Model: "gpt-4o", MaxTokens: 100})
+	if err == nil {
+		t.Fatal("Expected error when no choices are returned")
+	}
+}
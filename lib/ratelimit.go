@@ -0,0 +1,129 @@
+package lib
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// RateLimiter paces requests to stay under per-minute request and token
+// limits. Both limits refill continuously, so short bursts up to a full
+// minute's allowance are allowed. It is safe for concurrent use.
+type RateLimiter struct {
+	mu       sync.Mutex
+	requests *bucket
+	tokens   *bucket
+	now      func() time.Time
+}
+
+// NewRateLimiter creates a limiter allowing requestsPerMinute requests and
+// tokensPerMinute tokens. A limit of zero or less is not enforced.
+func NewRateLimiter(requestsPerMinute, tokensPerMinute int) *RateLimiter {
+	l := &RateLimiter{now: time.Now}
+	start := l.now()
+	if requestsPerMinute > 0 {
+		l.requests = newBucket(float64(requestsPerMinute), start)
+	}
+	if tokensPerMinute > 0 {
+		l.tokens = newBucket(float64(tokensPerMinute), start)
+	}
+	return l
+}
+
+// Wait blocks until a request expected to use tokens may be sent, then
+// reserves it. Requests larger than the token limit wait for a full bucket.
+func (l *RateLimiter) Wait(ctx context.Context, tokens int) error {
+	for {
+		l.mu.Lock()
+		now := l.now()
+		delay := max(l.requests.delay(1, now), l.tokens.delay(float64(tokens), now))
+		if delay == 0 {
+			l.requests.take(1)
+			l.tokens.take(float64(tokens))
+			l.mu.Unlock()
+			return nil
+		}
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+// Adjust corrects an earlier reservation once the real token count is known.
+// A positive delta charges more tokens, a negative one refunds them.
+func (l *RateLimiter) Adjust(delta int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.tokens.take(float64(delta))
+}
+
+// bucket is a token bucket refilling at capacity per minute. A nil bucket
+// never limits.
+type bucket struct {
+	capacity float64
+	level    float64
+	last     time.Time
+}
+
+func newBucket(capacity float64, now time.Time) *bucket {
+	return &bucket{capacity: capacity, level: capacity, last: now}
+}
+
+// reset sets the last refill time, for tests with a fake clock
+func (b *bucket) reset(now time.Time) {
+	if b != nil {
+		b.last = now
+	}
+}
+
+// delay refills the bucket and returns how long until n can be taken
+func (b *bucket) delay(n float64, now time.Time) time.Duration {
+	if b == nil {
+		return 0
+	}
+
+	perSecond := b.capacity / 60
+	b.level = min(b.capacity, b.level+now.Sub(b.last).Seconds()*perSecond)
+	b.last = now
+
+	need := min(n, b.capacity)
+	if b.level >= need {
+		return 0
+	}
+	return max(time.Duration((need-b.level)/perSecond*float64(time.Second)), time.Millisecond)
+}
+
+// take removes n from the bucket, which may go negative to record overuse
+func (b *bucket) take(n float64) {
+	if b == nil {
+		return
+	}
+	b.level = min(b.capacity, b.level-n)
+}
+
+// rateLimitedProvider waits on a RateLimiter before each call and corrects
+// the token reservation with the usage the provider reports
+type rateLimitedProvider struct {
+	Provider
+	limiter *RateLimiter
+}
+
+// Complete implements Provider
+func (p *rateLimitedProvider) Complete(ctx context.Context, req *Request) (*Response, error) {
+	reserved := EstimateTokens(req.Messages) + req.MaxTokens
+	if err := p.limiter.Wait(ctx, reserved); err != nil {
+		return nil, err
+	}
+
+	resp, err := p.Provider.Complete(ctx, req)
+	if err == nil && resp.Usage.TotalTokens > 0 {
+		p.limiter.Adjust(int(resp.Usage.TotalTokens) - reserved)
+	}
+	return resp, err
+}
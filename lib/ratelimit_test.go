@@ -0,0 +1,89 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// fakeClock returns a controllable time for rate limiter tests
+type fakeClock struct {
+	now time.Time
+}
+
+func (c *fakeClock) Now() time.Time {
+	return c.now
+}
+
+func newTestLimiter(requestsPerMinute, tokensPerMinute int) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(0, 0)}
+	limiter := NewRateLimiter(requestsPerMinute, tokensPerMinute)
+	limiter.now = clock.Now
+	limiter.requests.reset(clock.now)
+	limiter.tokens.reset(clock.now)
+	return limiter, clock
+}
+
+// waitNow reports whether Wait succeeds without blocking
+func waitNow(limiter *RateLimiter, tokens int) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Millisecond)
+	defer cancel()
+	return limiter.Wait(ctx, tokens) == nil
+}
+
+func TestRateLimiter_Requests(t *testing.T) {
+	limiter, clock := newTestLimiter(60, 0)
+
+	for i := 0; i < 60; i++ {
+		if !waitNow(limiter, 0) {
+			t.Fatalf("Expected request %d to be allowed", i)
+		}
+	}
+	if waitNow(limiter, 0) {
+		t.Error("Expected request 61 to wait")
+	}
+
+	// 60 per minute refills one request per second
+	clock.now = clock.now.Add(time.Second)
+	if !waitNow(limiter, 0) {
+		t.Error("Expected a request to be allowed after one second")
+	}
+}
+
+func TestRateLimiter_Tokens(t *testing.T) {
+	limiter, clock := newTestLimiter(0, 1000)
+
+	if !waitNow(limiter, 800) {
+		t.Fatal("Expected 800 tokens to be allowed")
+	}
+	if waitNow(limiter, 300) {
+		t.Error("Expected 300 more tokens to wait")
+	}
+
+	// The request used fewer tokens than reserved
+	limiter.Adjust(-500)
+	if !waitNow(limiter, 300) {
+		t.Error("Expected refunded tokens to be available")
+	}
+
+	// Requests larger than the limit wait for a full bucket
+	clock.now = clock.now.Add(time.Minute)
+	if !waitNow(limiter, 5000) {
+		t.Error("Expected an oversized request to be allowed with a full bucket")
+	}
+	if waitNow(limiter, 1) {
+		t.Error("Expected the bucket to be overdrawn after an oversized request")
+	}
+}
+
+func TestRateLimiter_Cancelled(t *testing.T) {
+	limiter, _ := newTestLimiter(1, 0)
+	waitNow(limiter, 0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := limiter.Wait(ctx, 0); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+}
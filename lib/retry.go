@@ -0,0 +1,150 @@
+package lib
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy controls how failed requests are retried
+type RetryPolicy struct {
+	// MaxAttempts is the total number of attempts, including the first; values
+	// below 2 disable retries
+	MaxAttempts int
+	// BaseDelay is the delay before the first retry; it doubles on each retry
+	BaseDelay time.Duration
+	// MaxDelay caps the backoff delay (zero means no cap)
+	MaxDelay time.Duration
+	// Jitter randomises each delay by up to this fraction (0-1) in either direction
+	Jitter float64
+	// RetryableStatus lists the HTTP status codes that are retried
+	RetryableStatus []int
+}
+
+// DefaultRetryPolicy returns the retry policy used by DefaultConfig
+func DefaultRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     3,
+		BaseDelay:       500 * time.Millisecond,
+		MaxDelay:        10 * time.Second,
+		Jitter:          0.2,
+		RetryableStatus: []int{408, 409, 429, 500, 502, 503, 504},
+	}
+}
+
+// retryable reports whether err should be retried and the server-requested delay
+func (p RetryPolicy) retryable(err error) (bool, time.Duration) {
+	apiErr, ok := asAPIError(err)
+	if !ok {
+		return false, 0
+	}
+	for _, status := range p.RetryableStatus {
+		if status == apiErr.StatusCode {
+			return true, apiErr.RetryAfter
+		}
+	}
+	return false, 0
+}
+
+// backoff returns the delay before the given retry (1 for the first retry)
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < retry; i++ {
+		delay *= 2
+		if p.MaxDelay > 0 && delay >= p.MaxDelay {
+			break
+		}
+	}
+	if p.MaxDelay > 0 && delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if p.Jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + p.Jitter*(2*rand.Float64()-1)))
+	}
+	return delay
+}
+
+// noRetryError marks an error that withRetry must return without retrying
+type noRetryError struct {
+	err error
+}
+
+func (e noRetryError) Error() string {
+	return e.err.Error()
+}
+
+// withRetry runs call according to the policy. It stops early when ctx is
+// done or its deadline would pass before the next attempt could start.
+func withRetry[R any](ctx context.Context, policy RetryPolicy, call func() (R, error)) (R, error) {
+	for attempt := 1; ; attempt++ {
+		result, err := call()
+		if stop, ok := err.(noRetryError); ok {
+			return result, stop.err
+		}
+		if err == nil || attempt >= policy.MaxAttempts {
+			return result, err
+		}
+
+		retry, retryAfter := policy.retryable(err)
+		if !retry {
+			return result, err
+		}
+
+		delay := policy.backoff(attempt)
+		if retryAfter > 0 {
+			delay = retryAfter
+		}
+		if deadline, ok := ctx.Deadline(); ok && time.Now().Add(delay).After(deadline) {
+			return result, err
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return result, err
+		case <-timer.C:
+		}
+	}
+}
+
+// complete sends req through the configured middleware and then client,
+// retrying according to config.Retry, and records the usage of the
+// successful attempt. Requests are answered from config.Cache when possible.
+func complete(ctx context.Context, client Provider, config *Config, req *Request) (*Response, error) {
+	return runMiddleware(ctx, config, req, func(ctx context.Context, req *Request) (*Response, error) {
+		return send(ctx, config, req, func() (*Response, error) {
+			return client.Complete(ctx, req)
+		})
+	})
+}
+
+// send answers req from config.Cache, or by calling call with retries
+// according to config.Retry. It caches, records and logs the response.
+func send(ctx context.Context, config *Config, req *Request, call func() (*Response, error)) (*Response, error) {
+	start := time.Now()
+	key, cached := cacheLookup(ctx, config, req)
+	if cached != nil {
+		recordUsage(ctx, req.Model, cached.Usage)
+		logCompletion(ctx, config, req, cached, nil, 0, time.Since(start))
+		return cached, nil
+	}
+
+	attempts := 0
+	resp, err := withRetry(ctx, config.Retry, func() (*Response, error) {
+		attempts++
+		resp, err := call()
+		if err != nil {
+			logAttemptFailure(ctx, config, req, attempts, err)
+		}
+		return resp, err
+	})
+	logCompletion(ctx, config, req, resp, err, attempts, time.Since(start))
+	if err != nil {
+		return nil, err
+	}
+
+	cacheStore(config, key, resp)
+	recordUsage(ctx, req.Model, resp.Usage)
+	return resp, nil
+}
@@ -0,0 +1,193 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+func testRetryPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts:     3,
+		BaseDelay:       time.Millisecond,
+		RetryableStatus: []int{429, 500},
+	}
+}
+
+func TestWithRetry_RetriesRetryableStatus(t *testing.T) {
+	calls := 0
+	result, err := withRetry(context.Background(), testRetryPolicy(), func() (string, error) {
+		calls++
+		if calls < 3 {
+			return "", newStatusError(429, nil, errors.New("slow down"))
+		}
+		return "done", nil
+	})
+
+	if err != nil || result != "done" {
+		t.Fatalf("Expected success after retries, got %q, %v", result, err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", calls)
+	}
+}
+
+func TestWithRetry_StopsOnNonRetryable(t *testing.T) {
+	calls := 0
+	_, err := withRetry(context.Background(), testRetryPolicy(), func() (string, error) {
+		calls++
+		return "", newStatusError(401, nil, errors.New("bad key"))
+	})
+
+	var authErr *AuthError
+	if !errors.As(err, &authErr) {
+		t.Errorf("Expected AuthError, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected 1 call, got %d", calls)
+	}
+}
+
+func TestWithRetry_MaxAttempts(t *testing.T) {
+	calls := 0
+	_, err := withRetry(context.Background(), testRetryPolicy(), func() (string, error) {
+		calls++
+		return "", newStatusError(500, nil, errors.New("down"))
+	})
+
+	var serverErr *ServerError
+	if !errors.As(err, &serverErr) {
+		t.Errorf("Expected ServerError, got %v", err)
+	}
+	if calls != 3 {
+		t.Errorf("Expected 3 calls, got %d", calls)
+	}
+}
+
+func TestWithRetry_RetryAfterBeyondDeadline(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	header := http.Header{"Retry-After": {"60"}}
+	calls := 0
+	start := time.Now()
+	_, err := withRetry(ctx, testRetryPolicy(), func() (string, error) {
+		calls++
+		return "", newStatusError(429, header, errors.New("slow down"))
+	})
+
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) || rateLimitErr.RetryAfter != time.Minute {
+		t.Errorf("Expected RateLimitError with RetryAfter, got %v", err)
+	}
+	if calls != 1 {
+		t.Errorf("Expected no retry past the deadline, got %d calls", calls)
+	}
+	if time.Since(start) > 40*time.Millisecond {
+		t.Error("Expected withRetry to return without waiting")
+	}
+}
+
+func TestRetryPolicy_Backoff(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: 300 * time.Millisecond}
+
+	want := []time.Duration{100 * time.Millisecond, 200 * time.Millisecond, 300 * time.Millisecond, 300 * time.Millisecond}
+	for i, w := range want {
+		if got := policy.backoff(i + 1); got != w {
+			t.Errorf("backoff(%d) = %v, want %v", i+1, got, w)
+		}
+	}
+
+	policy.Jitter = 0.5
+	for i := 0; i < 20; i++ {
+		if got := policy.backoff(1); got < 50*time.Millisecond || got > 150*time.Millisecond {
+			t.Errorf("Jittered backoff %v outside expected range", got)
+		}
+	}
+}
+
+func TestQuickQuery_RetriesOpenAIRateLimit(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		if calls == 1 {
+			w.Header().Set("Retry-After-Ms", "1")
+			w.WriteHeader(http.StatusTooManyRequests)
+			io.WriteString(w, `{"error":{"message":"Rate limit reached","type":"requests","code":"rate_limit_exceeded"}}`)
+			return
+		}
+		io.WriteString(w, `{"id":"chatcmpl-4","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"ok"}}]}`)
+	}))
+	defer server.Close()
+
+	client := openai.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+	config := DefaultConfig()
+	config.Retry = testRetryPolicy()
+
+	response, err := QuickQuery(context.Background(), NewOpenAIProvider(&client), config, "Hi", "System")
+	if err != nil {
+		t.Fatalf("QuickQuery failed: %v", err)
+	}
+	if response != "ok" || calls != 2 {
+		t.Errorf("Expected success on second call, got %q after %d calls", response, calls)
+	}
+}
+
+func TestOpenAIProvider_IgnoresSDKRetries(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Retry-After-Ms", "1")
+		w.WriteHeader(http.StatusInternalServerError)
+		io.WriteString(w, `{"error":{"message":"down"}}`)
+	}))
+	defer server.Close()
+
+	// The client keeps the SDK's default of 2 retries
+	client := openai.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL))
+	provider := NewOpenAIProvider(&client)
+	config := DefaultConfig()
+	config.Retry = RetryPolicy{MaxAttempts: 2, BaseDelay: time.Millisecond, RetryableStatus: []int{500}}
+
+	if _, err := QuickQuery(context.Background(), provider, config, "Hi", ""); err == nil {
+		t.Error("Expected an error")
+	}
+	if calls != 2 {
+		t.Errorf("Expected only Config.Retry's 2 attempts, got %d calls", calls)
+	}
+
+	calls = 0
+	if _, err := QuickQueryStream(context.Background(), provider, config, "Hi", "").Wait(); err == nil {
+		t.Error("Expected an error")
+	}
+	if calls != 2 {
+		t.Errorf("Expected only Config.Retry's 2 streaming attempts, got %d calls", calls)
+	}
+}
+
+func TestOpenAIProvider_AuthError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusUnauthorized)
+		io.WriteString(w, `{"error":{"message":"Incorrect API key","type":"invalid_request_error","code":"invalid_api_key"}}`)
+	}))
+	defer server.Close()
+
+	client := openai.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL), option.WithMaxRetries(0))
+
+	_, err := NewOpenAIProvider(&client).Complete(context.Background(), &Request{Model: "gpt-4o", MaxTokens: 10})
+
+	var authErr *AuthError
+	if !errors.As(err, &authErr) || authErr.StatusCode != 401 {
+		t.Errorf("Expected AuthError with status 401, got %v", err)
+	}
+}
@@ -0,0 +1,617 @@
+package lib
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	timeType          = reflect.TypeOf(time.Time{})
+	textMarshalerType = reflect.TypeOf((*encoding.TextMarshaler)(nil)).Elem()
+)
+
+// generateJSONSchema generates a JSON schema from a Go struct type using reflection.
+// The schema is restricted to what OpenAI's strict structured outputs accept.
+func generateJSONSchema(v interface{}) (map[string]interface{}, error) {
+	t := reflect.TypeOf(v)
+	if t == nil {
+		return nil, fmt.Errorf("cannot generate JSON schema for nil value")
+	}
+	return generateRootSchema(t)
+}
+
+// generateRootSchema generates a strict JSON schema for struct type t
+func generateRootSchema(t reflect.Type) (map[string]interface{}, error) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct || t == timeType {
+		return nil, fmt.Errorf("JSON schema root must be a struct, got %s", t)
+	}
+
+	return newSchemaReflector().reflect(t)
+}
+
+// generateFieldSchema generates a standalone schema for a single type
+func generateFieldSchema(t reflect.Type) (map[string]interface{}, error) {
+	return newSchemaReflector().reflect(t)
+}
+
+// schemaReflector converts Go types to the JSON schemas accepted by OpenAI
+// strict mode. Named struct types that are recursive or used more than once
+// are emitted under $defs and referenced with $ref; all other types are
+// inlined.
+type schemaReflector struct {
+	root      reflect.Type
+	uses      map[reflect.Type]int
+	recursive map[reflect.Type]bool
+	names     map[reflect.Type]string
+	defs      map[string]interface{}
+}
+
+// schemaField is a JSON-visible struct field after embedded struct flattening
+type schemaField struct {
+	name      string
+	field     reflect.StructField
+	stringOpt bool // json ",string" option
+	omitEmpty bool // json ",omitempty" option
+}
+
+func newSchemaReflector() *schemaReflector {
+	return &schemaReflector{
+		uses:      map[reflect.Type]int{},
+		recursive: map[reflect.Type]bool{},
+		names:     map[reflect.Type]string{},
+		defs:      map[string]interface{}{},
+	}
+}
+
+// reflect generates the schema for t, including any $defs it needs
+func (r *schemaReflector) reflect(t reflect.Type) (map[string]interface{}, error) {
+	r.root = t
+	if err := r.scan(t, map[reflect.Type]bool{}); err != nil {
+		return nil, err
+	}
+
+	var schema map[string]interface{}
+	var err error
+	if t.Kind() == reflect.Struct && !isStringType(t) {
+		schema, err = r.structSchema(t, t.Name())
+	} else {
+		schema, err = r.typeSchema(t, t.String())
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.defs) > 0 {
+		schema["$defs"] = r.defs
+	}
+	return schema, nil
+}
+
+// scan walks the type graph counting uses of named struct types and
+// detecting recursion, so generation knows which types need $defs entries
+func (r *schemaReflector) scan(t reflect.Type, stack map[reflect.Type]bool) error {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if isStringType(t) {
+		return nil
+	}
+
+	switch t.Kind() {
+	case reflect.Slice, reflect.Array:
+		return r.scan(t.Elem(), stack)
+	case reflect.Struct:
+		if t.Name() != "" {
+			r.uses[t]++
+			if stack[t] {
+				r.recursive[t] = true
+				return nil
+			}
+			if r.uses[t] > 1 {
+				return nil
+			}
+			stack[t] = true
+			defer delete(stack, t)
+		}
+
+		fields, err := structFields(t)
+		if err != nil {
+			return err
+		}
+		for _, f := range fields {
+			if err := r.scan(f.field.Type, stack); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// typeSchema generates the schema for a value of type t found at path
+func (r *schemaReflector) typeSchema(t reflect.Type, path string) (map[string]interface{}, error) {
+	if isStringType(t) {
+		if t == timeType {
+			return map[string]interface{}{"type": "string", "format": "date-time"}, nil
+		}
+		return map[string]interface{}{"type": "string"}, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Ptr:
+		inner, err := r.typeSchema(t.Elem(), path)
+		if err != nil {
+			return nil, err
+		}
+		return nullable(inner), nil
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return map[string]interface{}{"type": "integer", "minimum": 0}, nil
+	case reflect.Float32, reflect.Float64:
+		return map[string]interface{}{"type": "number"}, nil
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.Slice:
+		// encoding/json writes []byte as a base64 string
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]interface{}{"type": "string"}, nil
+		}
+		elemSchema, err := r.typeSchema(t.Elem(), path+"[]")
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"type":  "array",
+			"items": elemSchema,
+		}, nil
+	case reflect.Array:
+		elemSchema, err := r.typeSchema(t.Elem(), path+"[]")
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{
+			"type":     "array",
+			"items":    elemSchema,
+			"minItems": t.Len(),
+			"maxItems": t.Len(),
+		}, nil
+	case reflect.Map:
+		return nil, fmt.Errorf("%s: map type %s cannot be expressed in strict mode (use a slice of key/value structs instead)", path, t)
+	case reflect.Struct:
+		if t == r.root && r.recursive[t] {
+			return map[string]interface{}{"$ref": "#"}, nil
+		}
+		if t.Name() != "" && (r.uses[t] > 1 || r.recursive[t]) {
+			return r.defRef(t)
+		}
+		return r.structSchema(t, path)
+	case reflect.Interface:
+		return nil, fmt.Errorf("%s: interface type %s cannot be expressed in strict mode", path, t)
+	default:
+		return nil, fmt.Errorf("%s: unsupported type %s", path, t)
+	}
+}
+
+// structSchema generates an inline object schema for struct type t
+func (r *schemaReflector) structSchema(t reflect.Type, path string) (map[string]interface{}, error) {
+	fields, err := structFields(t)
+	if err != nil {
+		return nil, err
+	}
+
+	properties := map[string]interface{}{}
+	required := []string{}
+
+	for _, f := range fields {
+		fieldPath := path + "." + f.name
+
+		// Annotations apply to the pointed-to type; nullability is added after
+		ft := f.field.Type
+		isPtr := false
+		for ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+			isPtr = true
+		}
+
+		fieldSchema, err := r.typeSchema(ft, fieldPath)
+		if err != nil {
+			return nil, err
+		}
+		if f.stringOpt {
+			fieldSchema = stringOptSchema(ft, fieldSchema)
+		}
+		if tag, ok := f.field.Tag.Lookup("jsonschema"); ok {
+			fieldSchema, err = applySchemaTag(fieldSchema, ft, tag, fieldPath)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		// Strict mode requires every property, so optional fields become
+		// nullable instead
+		if isPtr || f.omitEmpty {
+			fieldSchema = nullable(fieldSchema)
+		}
+
+		properties[f.name] = fieldSchema
+		required = append(required, f.name)
+	}
+
+	return map[string]interface{}{
+		"type":                 "object",
+		"properties":           properties,
+		"required":             required,
+		"additionalProperties": false,
+	}, nil
+}
+
+// defRef returns a $ref to the $defs entry for t, generating it on first use
+func (r *schemaReflector) defRef(t reflect.Type) (map[string]interface{}, error) {
+	name, ok := r.names[t]
+	if !ok {
+		name = r.defName(t)
+		r.names[t] = name
+		// Reserve the entry before generating so recursive references resolve
+		r.defs[name] = nil
+		schema, err := r.structSchema(t, t.Name())
+		if err != nil {
+			return nil, err
+		}
+		r.defs[name] = schema
+	}
+	return map[string]interface{}{"$ref": "#/$defs/" + name}, nil
+}
+
+// defName picks a unique $defs key for t
+func (r *schemaReflector) defName(t reflect.Type) string {
+	base := sanitizeSchemaName(t.Name())
+	name := base
+	for i := 2; ; i++ {
+		if _, taken := r.defs[name]; !taken {
+			return name
+		}
+		name = fmt.Sprintf("%s_%d", base, i)
+	}
+}
+
+// structFields returns the JSON-visible fields of struct type t, flattening
+// untagged embedded structs the same way encoding/json does
+func structFields(t reflect.Type) ([]schemaField, error) {
+	type candidate struct {
+		schemaField
+		depth  int
+		tagged bool
+	}
+
+	var candidates []candidate
+	var walk func(t reflect.Type, depth int, visited map[reflect.Type]bool)
+	walk = func(t reflect.Type, depth int, visited map[reflect.Type]bool) {
+		if visited[t] {
+			return
+		}
+		visited[t] = true
+		defer delete(visited, t)
+
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+
+			jsonTag := field.Tag.Get("json")
+			if jsonTag == "-" {
+				continue
+			}
+
+			parts := strings.Split(jsonTag, ",")
+			fieldName := parts[0]
+
+			if field.Anonymous {
+				ft := field.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				// Skip unexported non-struct embeds, matching encoding/json
+				if !field.IsExported() && ft.Kind() != reflect.Struct {
+					continue
+				}
+				if fieldName == "" && ft.Kind() == reflect.Struct && !isStringType(ft) {
+					walk(ft, depth+1, visited)
+					continue
+				}
+			}
+
+			// Skip unexported fields
+			if !field.IsExported() {
+				continue
+			}
+
+			tagged := fieldName != ""
+			if !tagged {
+				fieldName = field.Name
+			}
+
+			stringOpt, omitEmpty := false, false
+			for _, opt := range parts[1:] {
+				switch opt {
+				case "string":
+					stringOpt = true
+				case "omitempty":
+					omitEmpty = true
+				}
+			}
+
+			candidates = append(candidates, candidate{
+				schemaField: schemaField{name: fieldName, field: field, stringOpt: stringOpt, omitEmpty: omitEmpty},
+				depth:       depth,
+				tagged:      tagged,
+			})
+		}
+	}
+	walk(t, 0, map[reflect.Type]bool{})
+
+	// Resolve name conflicts: the shallowest field wins, then a sole tagged
+	// field at that depth; otherwise all conflicting fields are dropped
+	byName := map[string][]candidate{}
+	var order []string
+	for _, c := range candidates {
+		if _, ok := byName[c.name]; !ok {
+			order = append(order, c.name)
+		}
+		byName[c.name] = append(byName[c.name], c)
+	}
+
+	fields := make([]schemaField, 0, len(order))
+	for _, name := range order {
+		group := byName[name]
+		minDepth := group[0].depth
+		for _, c := range group {
+			if c.depth < minDepth {
+				minDepth = c.depth
+			}
+		}
+
+		var shallowest, tagged []candidate
+		for _, c := range group {
+			if c.depth == minDepth {
+				shallowest = append(shallowest, c)
+				if c.tagged {
+					tagged = append(tagged, c)
+				}
+			}
+		}
+
+		switch {
+		case len(shallowest) == 1:
+			fields = append(fields, shallowest[0].schemaField)
+		case len(tagged) == 1:
+			fields = append(fields, tagged[0].schemaField)
+		}
+	}
+
+	return fields, nil
+}
+
+// applySchemaTag applies a `jsonschema:"..."` struct tag to a field schema.
+// The tag is a comma-separated list of key=value options; a literal comma is
+// escaped as \, (written `\\,` inside a struct tag literal). For slices and
+// arrays, value constraints (enum, minimum, maximum, pattern, format) apply to
+// the items while description, minItems and maxItems apply to the array itself.
+// A description on a field referencing $defs sits next to its $ref, since
+// strict structured outputs don't support allOf; every other option is
+// rejected there, as it is for any struct.
+func applySchemaTag(schema map[string]interface{}, t reflect.Type, tag, path string) (map[string]interface{}, error) {
+	options, err := parseSchemaTag(tag)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	isArray := (t.Kind() == reflect.Slice && t.Elem().Kind() != reflect.Uint8) || t.Kind() == reflect.Array
+	itemType := t
+	itemSchema := schema
+	if isArray {
+		itemType = t.Elem()
+		for itemType.Kind() == reflect.Ptr {
+			itemType = itemType.Elem()
+		}
+		items, _ := schema["items"].(map[string]interface{})
+		itemSchema = items
+	}
+
+	for _, opt := range options {
+		switch opt.key {
+		case "description":
+			schema["description"] = opt.value
+		case "minItems", "maxItems":
+			if !isArray {
+				return nil, fmt.Errorf("%s: %s only applies to slices and arrays, not %s", path, opt.key, t)
+			}
+			n, err := strconv.Atoi(opt.value)
+			if err != nil || n < 0 {
+				return nil, fmt.Errorf("%s: invalid %s value %q", path, opt.key, opt.value)
+			}
+			schema[opt.key] = n
+		case "enum":
+			if itemSchema == nil || itemSchema["type"] == nil {
+				return nil, fmt.Errorf("%s: enum only applies to scalar types, not %s", path, itemType)
+			}
+			var values []interface{}
+			for _, raw := range strings.Split(opt.value, "|") {
+				value, err := parseSchemaValue(raw, itemType)
+				if err != nil {
+					return nil, fmt.Errorf("%s: invalid enum value %q: %w", path, raw, err)
+				}
+				values = append(values, value)
+			}
+			itemSchema["enum"] = values
+		case "minimum", "maximum", "exclusiveMinimum", "exclusiveMaximum":
+			if !isNumberKind(itemType.Kind()) {
+				return nil, fmt.Errorf("%s: %s only applies to numeric types, not %s", path, opt.key, itemType)
+			}
+			value, err := parseSchemaValue(opt.value, itemType)
+			if err != nil {
+				return nil, fmt.Errorf("%s: invalid %s value %q: %w", path, opt.key, opt.value, err)
+			}
+			itemSchema[opt.key] = value
+		case "pattern", "format":
+			if itemSchema == nil || itemSchema["type"] != "string" {
+				return nil, fmt.Errorf("%s: %s only applies to string types, not %s", path, opt.key, itemType)
+			}
+			itemSchema[opt.key] = opt.value
+		default:
+			return nil, fmt.Errorf("%s: unknown jsonschema tag option %q", path, opt.key)
+		}
+	}
+
+	return schema, nil
+}
+
+// schemaTagOption is a single key=value entry of a jsonschema struct tag
+type schemaTagOption struct {
+	key   string
+	value string
+}
+
+// parseSchemaTag splits a jsonschema struct tag into its options. Each key
+// may appear only once.
+func parseSchemaTag(tag string) ([]schemaTagOption, error) {
+	var parts []string
+	var current strings.Builder
+	for i := 0; i < len(tag); i++ {
+		switch {
+		case tag[i] == '\\' && i+1 < len(tag) && tag[i+1] == ',':
+			current.WriteByte(',')
+			i++
+		case tag[i] == ',':
+			parts = append(parts, current.String())
+			current.Reset()
+		default:
+			current.WriteByte(tag[i])
+		}
+	}
+	parts = append(parts, current.String())
+
+	var options []schemaTagOption
+	seen := map[string]bool{}
+	for _, part := range parts {
+		if strings.TrimSpace(part) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			return nil, fmt.Errorf("jsonschema tag option %q must be key=value", part)
+		}
+		key = strings.TrimSpace(key)
+		// A repeated key would silently replace the earlier value, as with
+		// enum=a,enum=b instead of enum=a|b
+		if seen[key] {
+			return nil, fmt.Errorf("duplicate jsonschema tag option %q", key)
+		}
+		seen[key] = true
+		options = append(options, schemaTagOption{key: key, value: value})
+	}
+	return options, nil
+}
+
+// parseSchemaValue converts a tag value to the JSON type matching t
+func parseSchemaValue(raw string, t reflect.Type) (interface{}, error) {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(raw, 10, t.Bits())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return strconv.ParseUint(raw, 10, t.Bits())
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(raw, t.Bits())
+	case reflect.Bool:
+		return strconv.ParseBool(raw)
+	default:
+		return raw, nil
+	}
+}
+
+// isNumberKind reports whether k is an integer or floating point kind
+func isNumberKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return true
+	}
+	return false
+}
+
+// isStringType reports whether values of t are encoded as JSON strings
+// through encoding.TextMarshaler (this includes time.Time)
+func isStringType(t reflect.Type) bool {
+	if t.Kind() == reflect.Ptr || t.Kind() == reflect.Interface {
+		return false
+	}
+	return t.Implements(textMarshalerType) || reflect.PointerTo(t).Implements(textMarshalerType)
+}
+
+// stringOptSchema applies the json ",string" option, which encodes scalar
+// values as JSON strings
+func stringOptSchema(t reflect.Type, schema map[string]interface{}) map[string]interface{} {
+	switch t.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Bool:
+		return map[string]interface{}{"type": "string"}
+	}
+	return schema
+}
+
+// nullable wraps a schema so that it also accepts null
+func nullable(schema map[string]interface{}) map[string]interface{} {
+	if types, ok := schema["type"].([]string); ok {
+		for _, typ := range types {
+			if typ == "null" {
+				return schema
+			}
+		}
+	}
+
+	if typ, ok := schema["type"].(string); ok && schema["enum"] == nil {
+		result := make(map[string]interface{}, len(schema))
+		for k, v := range schema {
+			result[k] = v
+		}
+		result["type"] = []string{typ, "null"}
+		return result
+	}
+
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && len(schema) == 1 {
+		for _, s := range anyOf {
+			if m, ok := s.(map[string]interface{}); ok && m["type"] == "null" {
+				return schema
+			}
+		}
+	}
+
+	return map[string]interface{}{
+		"anyOf": []interface{}{schema, map[string]interface{}{"type": "null"}},
+	}
+}
+
+// sanitizeSchemaName converts a Go type name into a valid schema identifier
+func sanitizeSchemaName(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		switch {
+		case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_', r == '-':
+			b.WriteRune(r)
+		default:
+			b.WriteRune('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "object"
+	}
+	return b.String()
+}
@@ -0,0 +1,359 @@
+package lib
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestGenerateJSONSchema_Pointers(t *testing.T) {
+	type Inner struct {
+		Value string `json:"value"`
+	}
+	type TestStruct struct {
+		Name  *string `json:"name"`
+		Inner *Inner  `json:"inner"`
+	}
+
+	schema, err := generateJSONSchema(TestStruct{})
+	if err != nil {
+		t.Fatalf("Failed to generate schema: %v", err)
+	}
+
+	properties := schema["properties"].(map[string]interface{})
+
+	nameSchema := properties["name"].(map[string]interface{})
+	if !reflect.DeepEqual(nameSchema["type"], []string{"string", "null"}) {
+		t.Errorf("Expected nullable string type, got %v", nameSchema["type"])
+	}
+
+	innerSchema := properties["inner"].(map[string]interface{})
+	if !reflect.DeepEqual(innerSchema["type"], []string{"object", "null"}) {
+		t.Errorf("Expected nullable object type, got %v", innerSchema["type"])
+	}
+}
+
+func TestGenerateJSONSchema_TimeAndUnsigned(t *testing.T) {
+	type TestStruct struct {
+		CreatedAt time.Time `json:"created_at"`
+		Count     uint32    `json:"count"`
+		Data      []byte    `json:"data"`
+	}
+
+	schema, err := generateJSONSchema(TestStruct{})
+	if err != nil {
+		t.Fatalf("Failed to generate schema: %v", err)
+	}
+
+	properties := schema["properties"].(map[string]interface{})
+
+	createdAt := properties["created_at"].(map[string]interface{})
+	if createdAt["type"] != "string" || createdAt["format"] != "date-time" {
+		t.Errorf("Expected date-time string, got %v", createdAt)
+	}
+
+	count := properties["count"].(map[string]interface{})
+	if count["type"] != "integer" || count["minimum"] != 0 {
+		t.Errorf("Expected non-negative integer, got %v", count)
+	}
+
+	data := properties["data"].(map[string]interface{})
+	if data["type"] != "string" {
+		t.Errorf("Expected []byte to be a string, got %v", data)
+	}
+}
+
+func TestGenerateJSONSchema_EmbeddedStruct(t *testing.T) {
+	type Base struct {
+		ID   string `json:"id"`
+		Name string `json:"name"`
+	}
+	type TestStruct struct {
+		Base
+		Name  string `json:"name"`
+		Extra string `json:"extra"`
+	}
+
+	schema, err := generateJSONSchema(TestStruct{})
+	if err != nil {
+		t.Fatalf("Failed to generate schema: %v", err)
+	}
+
+	properties := schema["properties"].(map[string]interface{})
+	if len(properties) != 3 {
+		t.Errorf("Expected 3 flattened properties, got %v", properties)
+	}
+	for _, name := range []string{"id", "name", "extra"} {
+		if _, ok := properties[name]; !ok {
+			t.Errorf("Expected property %s", name)
+		}
+	}
+
+	required := schema["required"].([]string)
+	if !reflect.DeepEqual(required, []string{"id", "name", "extra"}) {
+		t.Errorf("Unexpected required fields: %v", required)
+	}
+}
+
+type treeNode struct {
+	Label    string      `json:"label"`
+	Children []*treeNode `json:"children"`
+}
+
+type personRef struct {
+	Name string `json:"name"`
+}
+
+type team struct {
+	Lead    personRef   `json:"lead"`
+	Members []personRef `json:"members"`
+	Root    treeNode    `json:"root"`
+}
+
+func TestGenerateJSONSchema_RecursiveRoot(t *testing.T) {
+	schema, err := generateJSONSchema(treeNode{})
+	if err != nil {
+		t.Fatalf("Failed to generate schema: %v", err)
+	}
+
+	children := schema["properties"].(map[string]interface{})["children"].(map[string]interface{})
+	items := children["items"].(map[string]interface{})
+	anyOf, ok := items["anyOf"].([]interface{})
+	if !ok || len(anyOf) != 2 {
+		t.Fatalf("Expected nullable ref for recursive pointer, got %v", items)
+	}
+	if ref := anyOf[0].(map[string]interface{})["$ref"]; ref != "#" {
+		t.Errorf("Expected $ref '#', got %v", ref)
+	}
+}
+
+func TestGenerateJSONSchema_Defs(t *testing.T) {
+	schema, err := generateJSONSchema(team{})
+	if err != nil {
+		t.Fatalf("Failed to generate schema: %v", err)
+	}
+
+	defs, ok := schema["$defs"].(map[string]interface{})
+	if !ok {
+		t.Fatal("Expected $defs for repeated and recursive types")
+	}
+	if _, ok := defs["personRef"]; !ok {
+		t.Error("Expected personRef in $defs")
+	}
+	if _, ok := defs["treeNode"]; !ok {
+		t.Error("Expected treeNode in $defs")
+	}
+
+	properties := schema["properties"].(map[string]interface{})
+	if ref := properties["lead"].(map[string]interface{})["$ref"]; ref != "#/$defs/personRef" {
+		t.Errorf("Expected lead to reference personRef, got %v", ref)
+	}
+	if ref := properties["root"].(map[string]interface{})["$ref"]; ref != "#/$defs/treeNode" {
+		t.Errorf("Expected root to reference treeNode, got %v", ref)
+	}
+}
+
+func TestGenerateJSONSchema_TaggedRef(t *testing.T) {
+	type addr struct {
+		City string `json:"city"`
+	}
+	type shipping struct {
+		Home  addr  `json:"home" jsonschema:"description=Where the customer lives"`
+		Work  addr  `json:"work"`
+		Depot *addr `json:"depot" jsonschema:"description=Nearest pickup point"`
+	}
+
+	schema, err := generateJSONSchema(shipping{})
+	if err != nil {
+		t.Fatalf("Failed to generate schema: %v", err)
+	}
+	data, _ := json.Marshal(schema)
+	if strings.Contains(string(data), "allOf") {
+		t.Errorf("Expected no allOf, got %s", data)
+	}
+
+	properties := schema["properties"].(map[string]interface{})
+	home := properties["home"].(map[string]interface{})
+	if home["$ref"] != "#/$defs/addr" || home["description"] != "Where the customer lives" || len(home) != 2 {
+		t.Errorf("Expected the description next to the $ref, got %v", home)
+	}
+	if work := properties["work"].(map[string]interface{}); len(work) != 1 {
+		t.Errorf("Expected an untagged $ref alone, got %v", work)
+	}
+	depot, _ := json.Marshal(properties["depot"])
+	if expected := `{"anyOf":[{"$ref":"#/$defs/addr","description":"Nearest pickup point"},{"type":"null"}]}`; string(depot) != expected {
+		t.Errorf("Expected %s, got %s", expected, depot)
+	}
+
+	_, err = generateJSONSchema(struct {
+		A addr `json:"a" jsonschema:"pattern=x"`
+		B addr `json:"b"`
+	}{})
+	if err == nil || !strings.Contains(err.Error(), "a: pattern only applies to string types") {
+		t.Errorf("Expected other options on a $ref to be rejected, got %v", err)
+	}
+}
+
+func TestGenerateJSONSchema_StrictErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		wantErr string
+	}{
+		{"map", struct {
+			Labels map[string]string `json:"labels"`
+		}{}, "map type"},
+		{"interface", struct {
+			Any interface{} `json:"any"`
+		}{}, "interface type"},
+		{"chan", struct {
+			Ch chan int `json:"ch"`
+		}{}, "unsupported type"},
+		{"non-struct root", []string{}, "must be a struct"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := generateJSONSchema(tt.value)
+			if err == nil {
+				t.Fatal("Expected error")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %q", tt.wantErr, err.Error())
+			}
+		})
+	}
+}
+
+func TestGenerateJSONSchema_StringOption(t *testing.T) {
+	type TestStruct struct {
+		ID int64 `json:"id,string"`
+	}
+
+	schema, err := generateJSONSchema(TestStruct{})
+	if err != nil {
+		t.Fatalf("Failed to generate schema: %v", err)
+	}
+
+	id := schema["properties"].(map[string]interface{})["id"].(map[string]interface{})
+	if id["type"] != "string" {
+		t.Errorf("Expected string type for ,string option, got %v", id["type"])
+	}
+}
+
+func TestGenerateJSONSchema_TagAnnotations(t *testing.T) {
+	type TestStruct struct {
+		Level  int      `json:"level" jsonschema:"description=Severity level,enum=1|2|3"`
+		Score  float64  `json:"score" jsonschema:"minimum=0,maximum=1"`
+		Code   string   `json:"code" jsonschema:"pattern=^[A-Z]{3}$,description=Three letters\\, uppercase"`
+		Tags   []string `json:"tags" jsonschema:"enum=a|b,maxItems=2"`
+		Status *string  `json:"status" jsonschema:"enum=open|closed"`
+	}
+
+	schema, err := generateJSONSchema(TestStruct{})
+	if err != nil {
+		t.Fatalf("Failed to generate schema: %v", err)
+	}
+
+	properties := schema["properties"].(map[string]interface{})
+
+	level := properties["level"].(map[string]interface{})
+	if level["description"] != "Severity level" {
+		t.Errorf("Expected description, got %v", level["description"])
+	}
+	if !reflect.DeepEqual(level["enum"], []interface{}{int64(1), int64(2), int64(3)}) {
+		t.Errorf("Expected integer enum, got %v", level["enum"])
+	}
+
+	score := properties["score"].(map[string]interface{})
+	if score["minimum"] != float64(0) || score["maximum"] != float64(1) {
+		t.Errorf("Expected range 0-1, got %v", score)
+	}
+
+	code := properties["code"].(map[string]interface{})
+	if code["pattern"] != "^[A-Z]{3}$" || code["description"] != "Three letters, uppercase" {
+		t.Errorf("Unexpected code schema: %v", code)
+	}
+
+	tags := properties["tags"].(map[string]interface{})
+	if tags["maxItems"] != 2 {
+		t.Errorf("Expected maxItems on array, got %v", tags)
+	}
+	items := tags["items"].(map[string]interface{})
+	if !reflect.DeepEqual(items["enum"], []interface{}{"a", "b"}) {
+		t.Errorf("Expected enum on items, got %v", items)
+	}
+
+	status := properties["status"].(map[string]interface{})
+	anyOf, ok := status["anyOf"].([]interface{})
+	if !ok || len(anyOf) != 2 {
+		t.Fatalf("Expected nullable enum via anyOf, got %v", status)
+	}
+	if !reflect.DeepEqual(anyOf[0].(map[string]interface{})["enum"], []interface{}{"open", "closed"}) {
+		t.Errorf("Expected enum inside anyOf, got %v", anyOf[0])
+	}
+}
+
+func TestGenerateJSONSchema_OmitEmpty(t *testing.T) {
+	type TestStruct struct {
+		Name string `json:"name"`
+		Note string `json:"note,omitempty"`
+	}
+
+	schema, err := generateJSONSchema(TestStruct{})
+	if err != nil {
+		t.Fatalf("Failed to generate schema: %v", err)
+	}
+
+	// Strict mode keeps every field required but makes omitempty fields nullable
+	if !reflect.DeepEqual(schema["required"], []string{"name", "note"}) {
+		t.Errorf("Expected all fields required in strict mode, got %v", schema["required"])
+	}
+	note := schema["properties"].(map[string]interface{})["note"].(map[string]interface{})
+	if !reflect.DeepEqual(note["type"], []string{"string", "null"}) {
+		t.Errorf("Expected nullable note, got %v", note["type"])
+	}
+}
+
+func TestGenerateJSONSchema_TagErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		value   interface{}
+		wantErr string
+	}{
+		{"unknown option", struct {
+			A string `json:"a" jsonschema:"colour=red"`
+		}{}, "unknown jsonschema tag option"},
+		{"bad enum value", struct {
+			A int `json:"a" jsonschema:"enum=1|two"`
+		}{}, "invalid enum value"},
+		{"minimum on string", struct {
+			A string `json:"a" jsonschema:"minimum=1"`
+		}{}, "only applies to numeric types"},
+		{"missing value", struct {
+			A string `json:"a" jsonschema:"description"`
+		}{}, "must be key=value"},
+		{"duplicate enum", struct {
+			Label string `json:"label" jsonschema:"enum=positive,enum=neutral"`
+		}{}, `label: duplicate jsonschema tag option "enum"`},
+		{"duplicate description", struct {
+			Inner struct {
+				A string `json:"a" jsonschema:"description=first,description=second"`
+			} `json:"inner"`
+		}{}, `inner.a: duplicate jsonschema tag option "description"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := generateJSONSchema(tt.value)
+			if err == nil {
+				t.Fatal("Expected error")
+			}
+			if !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Expected error containing %q, got %q", tt.wantErr, err.Error())
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+package lib
+
+import "context"
+
+// StreamingProvider is implemented by providers that can deliver a completion
+// incrementally. Providers that don't implement it are still usable with the
+// streaming API; the full content is then delivered as a single delta.
+type StreamingProvider interface {
+	Provider
+	CompleteStream(ctx context.Context, req *Request, onDelta func(string)) (*Response, error)
+}
+
+// Stream delivers the content of a completion as it is generated
+type Stream struct {
+	deltas chan string
+	done   chan struct{}
+	resp   *Response
+	err    error
+}
+
+// Deltas returns a channel of content fragments. It is closed once the
+// completion has finished or failed.
+func (s *Stream) Deltas() <-chan string {
+	return s.deltas
+}
+
+// Wait blocks until the stream finishes and returns the aggregated response.
+// Any deltas not yet read are discarded.
+func (s *Stream) Wait() (*Response, error) {
+	for range s.deltas {
+	}
+	<-s.done
+	return s.resp, s.err
+}
+
+// startStream runs fn in the background, forwarding its deltas to the returned
+// Stream. onDone, if non-nil, is called with the final result before the
+// stream is marked done, so state updates are visible once Wait returns.
+func startStream(ctx context.Context, fn func(onDelta func(string)) (*Response, error), onDone func(*Response, error) (*Response, error)) *Stream {
+	s := &Stream{
+		deltas: make(chan string, 16),
+		done:   make(chan struct{}),
+	}
+
+	go func() {
+		defer close(s.done)
+
+		resp, err := fn(func(delta string) {
+			select {
+			case s.deltas <- delta:
+			case <-ctx.Done():
+			}
+		})
+		if err == nil && ctx.Err() != nil {
+			err = ctx.Err()
+		}
+		close(s.deltas)
+
+		if onDone != nil {
+			resp, err = onDone(resp, err)
+		}
+		s.resp, s.err = resp, err
+	}()
+
+	return s
+}
+
+// completeStream streams req through the configured middleware and then
+// client, falling back to a single blocking call for providers that don't
+// support streaming. Failures are retried according to config.Retry only
+// until the first delta is delivered. A response that arrives without
+// deltas, such as one from config.Cache or a middleware, is delivered as a
+// single delta.
+func completeStream(ctx context.Context, client Provider, config *Config, req *Request, onDelta func(string)) (*Response, error) {
+	started := false
+	forward := func(delta string) {
+		started = true
+		onDelta(delta)
+	}
+
+	resp, err := runMiddleware(ctx, config, req, func(ctx context.Context, req *Request) (*Response, error) {
+		return send(ctx, config, req, func() (*Response, error) {
+			var resp *Response
+			var err error
+			if sp, ok := client.(StreamingProvider); ok {
+				resp, err = sp.CompleteStream(ctx, req, forward)
+			} else {
+				resp, err = client.Complete(ctx, req)
+			}
+			if err != nil && started {
+				return nil, noRetryError{err}
+			}
+			return resp, err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !started && resp.Content != "" {
+		forward(resp.Content)
+	}
+	return resp, nil
+}
+
+// QuickQueryStreamFromEnv performs a single streaming query using environment configuration
+func QuickQueryStreamFromEnv(ctx context.Context, prompt, systemPrompt string) (*Stream, error) {
+	client, config, err := NewClientFromEnv()
+	if err != nil {
+		return nil, err
+	}
+
+	return QuickQueryStream(ctx, client, config, prompt, systemPrompt), nil
+}
+
+// QuickQueryStream performs a single streaming query against the given provider
+func QuickQueryStream(ctx context.Context, client Provider, config *Config, prompt, systemPrompt string) *Stream {
+	messages := []Message{
+		{Role: "system", Content: systemPrompt},
+		{Role: "user", Content: prompt},
+	}
+	req := newRequest(config, messages)
+
+	return startStream(ctx, func(onDelta func(string)) (*Response, error) {
+		return completeStream(ctx, client, config, req, onDelta)
+	}, func(resp *Response, err error) (*Response, error) {
+		if err != nil {
+			return nil, err
+		}
+		if err := checkResponse(config.Model, resp); err != nil {
+			return nil, err
+		}
+		return resp, nil
+	})
+}
@@ -0,0 +1,155 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// fakeStreamingProvider streams a fixed set of deltas, optionally blocking
+// after the first one until its context is cancelled
+type fakeStreamingProvider struct {
+	fakeProvider
+	deltas []string
+	block  bool
+}
+
+func (f *fakeStreamingProvider) CompleteStream(ctx context.Context, req *Request, onDelta func(string)) (*Response, error) {
+	for i, delta := range f.deltas {
+		onDelta(delta)
+		if f.block && i == 0 {
+			<-ctx.Done()
+			return nil, ctx.Err()
+		}
+	}
+	return &Response{ID: "stream", Model: req.Model, Content: strings.Join(f.deltas, ""), FinishReason: "stop"}, nil
+}
+
+func collect(s *Stream) string {
+	var b strings.Builder
+	for delta := range s.Deltas() {
+		b.WriteString(delta)
+	}
+	return b.String()
+}
+
+func TestQuickQueryStream(t *testing.T) {
+	provider := &fakeStreamingProvider{deltas: []string{"Hel", "lo"}}
+
+	stream := QuickQueryStream(context.Background(), provider, DefaultConfig(), "Hi", "System")
+	if got := collect(stream); got != "Hello" {
+		t.Errorf("Expected streamed content 'Hello', got '%s'", got)
+	}
+
+	resp, err := stream.Wait()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Content != "Hello" {
+		t.Errorf("Expected aggregated content 'Hello', got '%s'", resp.Content)
+	}
+}
+
+func TestQuickQueryStream_NonStreamingProvider(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{{ID: "1", Content: "whole answer", FinishReason: "stop"}},
+	}
+
+	stream := QuickQueryStream(context.Background(), provider, DefaultConfig(), "Hi", "System")
+	if got := collect(stream); got != "whole answer" {
+		t.Errorf("Expected single delta 'whole answer', got '%s'", got)
+	}
+
+	if _, err := stream.Wait(); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+}
+
+func TestConversation_SendMessageStream(t *testing.T) {
+	provider := &fakeStreamingProvider{deltas: []string{"Hi ", "there"}}
+	conv := NewConversation(provider, DefaultConfig(), "System")
+
+	// Wait without reading deltas must not block
+	resp, err := conv.SendMessageStream(context.Background(), "Hello").Wait()
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if resp.Content != "Hi there" {
+		t.Errorf("Expected 'Hi there', got '%s'", resp.Content)
+	}
+
+	history := conv.GetHistory()
+	if len(history) != 3 || history[1].Content != "Hello" || history[2].Content != "Hi there" {
+		t.Errorf("Unexpected history after stream: %+v", history)
+	}
+	if len(conv.messages) != 3 {
+		t.Errorf("Expected 3 messages, got %d", len(conv.messages))
+	}
+}
+
+func TestConversation_SendMessageStream_Cancelled(t *testing.T) {
+	provider := &fakeStreamingProvider{deltas: []string{"partial", "rest"}, block: true}
+	conv := NewConversation(provider, DefaultConfig(), "System")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := conv.SendMessageStream(ctx, "Hello")
+
+	if delta := <-stream.Deltas(); delta != "partial" {
+		t.Errorf("Expected first delta 'partial', got '%s'", delta)
+	}
+	cancel()
+
+	_, err := stream.Wait()
+	if !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected context.Canceled, got %v", err)
+	}
+
+	if len(conv.GetHistory()) != 1 || len(conv.messages) != 1 {
+		t.Errorf("Expected conversation to be unchanged after cancellation, got %+v", conv.GetHistory())
+	}
+}
+
+func TestOpenAIProvider_CompleteStream(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		chunks := []string{
+			`{"id":"chatcmpl-3","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"role":"assistant","content":"Hel"}}]}`,
+			`{"id":"chatcmpl-3","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{"content":"lo"}}]}`,
+			`{"id":"chatcmpl-3","object":"chat.completion.chunk","model":"gpt-4o","choices":[{"index":0,"delta":{},"finish_reason":"stop"}]}`,
+			`{"id":"chatcmpl-3","object":"chat.completion.chunk","model":"gpt-4o","choices":[],"usage":{"prompt_tokens":5,"completion_tokens":2,"total_tokens":7}}`,
+		}
+		for _, chunk := range chunks {
+			io.WriteString(w, "data: "+chunk+"\n\n")
+		}
+		io.WriteString(w, "data: [DONE]\n\n")
+	}))
+	defer server.Close()
+
+	client := openai.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL))
+	provider := NewOpenAIProvider(&client)
+
+	var deltas []string
+	resp, err := provider.CompleteStream(context.Background(), &Request{Model: "gpt-4o", MaxTokens: 100}, func(delta string) {
+		deltas = append(deltas, delta)
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if len(deltas) != 2 {
+		t.Errorf("Expected 2 deltas, got %v", deltas)
+	}
+	if resp.Content != "Hello" || resp.FinishReason != "stop" || resp.ID != "chatcmpl-3" {
+		t.Errorf("Unexpected aggregated response: %+v", resp)
+	}
+	if resp.Usage.TotalTokens != 7 {
+		t.Errorf("Expected 7 total tokens from the usage chunk, got %d", resp.Usage.TotalTokens)
+	}
+}
@@ -0,0 +1,283 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// StructuredOption configures a structured query
+type StructuredOption func(*structuredOptions)
+
+type structuredOptions struct {
+	config       *Config
+	systemPrompt string
+	usage        *Usage
+}
+
+// WithConfig sets the model configuration for a structured query
+func WithConfig(config *Config) StructuredOption {
+	return func(o *structuredOptions) {
+		o.config = config
+	}
+}
+
+// WithSystemPrompt sets the system prompt for a structured query
+func WithSystemPrompt(systemPrompt string) StructuredOption {
+	return func(o *structuredOptions) {
+		o.systemPrompt = systemPrompt
+	}
+}
+
+// WithUsage adds the token usage of every request made by the structured
+// query, including repair and truncation retries, to usage
+func WithUsage(usage *Usage) StructuredOption {
+	return func(o *structuredOptions) {
+		o.usage = usage
+	}
+}
+
+// Structured performs a structured query and decodes the response into a T.
+// T must be a struct (or pointer to struct) whose schema OpenAI strict mode
+// can express; this is checked before any request is sent.
+func Structured[T any](ctx context.Context, client Provider, prompt string, opts ...StructuredOption) (T, error) {
+	options := structuredOptions{config: DefaultConfig()}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	var result T
+	t := reflect.TypeOf((*T)(nil)).Elem()
+	if err := runStructured(ctx, client, options, prompt, t, &result); err != nil {
+		var zero T
+		return zero, err
+	}
+	return result, nil
+}
+
+// StructuredQueryFromEnv performs a structured query using OpenAI's native structured outputs
+func StructuredQueryFromEnv(ctx context.Context, prompt, systemPrompt string, target interface{}) error {
+	client, config, err := NewClientFromEnv()
+	if err != nil {
+		return err
+	}
+
+	return StructuredQuery(ctx, client, config, prompt, systemPrompt, target)
+}
+
+// StructuredQuery performs a structured query against the given provider,
+// decoding the response into target, which must be a non-nil pointer to a struct
+func StructuredQuery(ctx context.Context, client Provider, config *Config, prompt, systemPrompt string, target interface{}) error {
+	v := reflect.ValueOf(target)
+	if v.Kind() != reflect.Ptr || v.IsNil() {
+		return fmt.Errorf("structured query target must be a non-nil pointer to a struct, got %T", target)
+	}
+
+	options := structuredOptions{config: config, systemPrompt: systemPrompt}
+	return runStructured(ctx, client, options, prompt, v.Type().Elem(), target)
+}
+
+// Validator is implemented by structured output types that can check their
+// own semantic validity after decoding
+type Validator interface {
+	Validate() error
+}
+
+// ValidationError is returned when a structured response still fails
+// validation after all repair attempts
+type ValidationError struct {
+	Attempts []ValidationAttempt
+}
+
+// ValidationAttempt records one response and the validation error it produced
+type ValidationAttempt struct {
+	Content string
+	Err     error
+}
+
+func (e *ValidationError) Error() string {
+	last := e.Attempts[len(e.Attempts)-1]
+	return fmt.Sprintf("structured response failed validation after %d attempt(s): %v", len(e.Attempts), last.Err)
+}
+
+// Unwrap returns the validation error from the final attempt
+func (e *ValidationError) Unwrap() error {
+	return e.Attempts[len(e.Attempts)-1].Err
+}
+
+// runStructured sends a one-shot structured query for type t and decodes the
+// response into target
+func runStructured(ctx context.Context, client Provider, options structuredOptions, prompt string, t reflect.Type, target interface{}) error {
+	schema, err := structuredSchema(options.config, t)
+	if err != nil {
+		return err
+	}
+
+	messages := []Message{
+		{Role: "system", Content: options.systemPrompt},
+		{Role: "user", Content: prompt},
+	}
+
+	_, err = decodeStructured(options.config, messages, schema, t, target, func(req *Request) (*Response, error) {
+		resp, err := complete(ctx, client, options.config, req)
+		if err == nil && options.usage != nil {
+			*options.usage = options.usage.Add(resp.Usage)
+		}
+		return resp, err
+	})
+	return err
+}
+
+// structuredSchema checks that config's model supports structured outputs
+// and returns the response schema for t
+func structuredSchema(config *Config, t reflect.Type) (*ResponseSchema, error) {
+	if info, _ := LookupModel(config.Model); !info.SupportsStructuredOutputs {
+		return nil, fmt.Errorf("model %s does not support structured outputs", config.Model)
+	}
+	schema, err := responseSchemaFor(t)
+	if err != nil {
+		return nil, err
+	}
+	// The schema ends up on requests that middleware may change, so each
+	// caller gets its own copy of the cached one
+	return copySchema(schema), nil
+}
+
+// decodeStructured sends messages with schema using send and decodes the
+// response into target, returning the accepted JSON. If the decoded value
+// implements Validator and fails, the error is sent back to the model for up
+// to config.ValidationRetries repair turns. Truncated responses are retried
+// with a doubled token budget, up to config.TruncationMaxTokens.
+func decodeStructured(config *Config, messages []Message, schema *ResponseSchema, t reflect.Type, target interface{}, send func(req *Request) (*Response, error)) (string, error) {
+	maxTokens := config.MaxTokens
+	var attempts []ValidationAttempt
+	for {
+		req := newRequest(config, messages)
+		req.MaxTokens = maxTokens
+		req.Schema = schema
+
+		resp, err := send(req)
+		if err != nil {
+			return "", err
+		}
+
+		err = checkResponse(config.Model, resp)
+		if errors.Is(err, ErrTruncated) && maxTokens < config.TruncationMaxTokens {
+			maxTokens = min(max(maxTokens*2, 1), config.TruncationMaxTokens)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		content := resp.Content
+
+		// Reset target so fields from a rejected attempt don't leak into the next
+		reflect.ValueOf(target).Elem().Set(reflect.Zero(t))
+
+		err = json.Unmarshal([]byte(content), target)
+		if err != nil {
+			return "", fmt.Errorf("failed to parse JSON response: %w (content preview: %.100s...)", err, content)
+		}
+
+		err = validateTarget(target)
+		if err == nil {
+			return content, nil
+		}
+
+		attempts = append(attempts, ValidationAttempt{Content: content, Err: err})
+		if len(attempts) > config.ValidationRetries {
+			return "", &ValidationError{Attempts: attempts}
+		}
+
+		messages = append(messages,
+			Message{Role: "assistant", Content: content},
+			Message{Role: "user", Content: fmt.Sprintf("That response failed validation: %v\nReturn a corrected response.", err)},
+		)
+	}
+}
+
+// validateTarget runs Validate on target, or on the value it points to
+func validateTarget(target interface{}) error {
+	if v, ok := target.(Validator); ok {
+		return v.Validate()
+	}
+
+	elem := reflect.ValueOf(target).Elem()
+	if elem.Kind() == reflect.Ptr && elem.IsNil() {
+		return nil
+	}
+	if v, ok := elem.Interface().(Validator); ok {
+		return v.Validate()
+	}
+	return nil
+}
+
+// schemaCache holds generated response schemas (or generation errors) keyed by reflect.Type
+var schemaCache sync.Map
+
+type cachedSchema struct {
+	schema *ResponseSchema
+	err    error
+}
+
+// responseSchemaFor returns the strict response schema for t, generating it on first use
+func responseSchemaFor(t reflect.Type) (*ResponseSchema, error) {
+	if cached, ok := schemaCache.Load(t); ok {
+		entry := cached.(*cachedSchema)
+		return entry.schema, entry.err
+	}
+
+	entry := &cachedSchema{}
+	schema, err := generateRootSchema(t)
+	if err != nil {
+		entry.err = fmt.Errorf("failed to generate JSON schema: %w", err)
+	} else {
+		// Create schema name from struct type
+		named := t
+		for named.Kind() == reflect.Ptr {
+			named = named.Elem()
+		}
+		entry.schema = &ResponseSchema{
+			Name:   sanitizeSchemaName(strings.ToLower(named.Name())),
+			Schema: schema,
+			Strict: true,
+		}
+	}
+
+	cached, _ := schemaCache.LoadOrStore(t, entry)
+	entry = cached.(*cachedSchema)
+	return entry.schema, entry.err
+}
+
+// copySchema returns a deep copy of schema
+func copySchema(schema *ResponseSchema) *ResponseSchema {
+	result := *schema
+	result.Schema = copySchemaValue(schema.Schema).(map[string]interface{})
+	return &result
+}
+
+// copySchemaValue deep copies the maps and slices in a generated JSON schema
+func copySchemaValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		result := make(map[string]interface{}, len(v))
+		for key, value := range v {
+			result[key] = copySchemaValue(value)
+		}
+		return result
+	case []interface{}:
+		result := make([]interface{}, len(v))
+		for i, value := range v {
+			result[i] = copySchemaValue(value)
+		}
+		return result
+	case []string:
+		return append([]string(nil), v...)
+	default:
+		return v
+	}
+}
@@ -0,0 +1,260 @@
+package lib
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type structuredAnswer struct {
+	Answer string `json:"answer"`
+	Score  int    `json:"score"`
+}
+
+func TestStructured(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{{ID: "1", Content: `{"answer":"4","score":9}`, FinishReason: "stop"}},
+	}
+
+	config := &Config{Model: "gpt-4o", MaxTokens: 200}
+	result, err := Structured[structuredAnswer](context.Background(), provider, "2+2?",
+		WithConfig(config), WithSystemPrompt("You are a math assistant."))
+	if err != nil {
+		t.Fatalf("Structured failed: %v", err)
+	}
+
+	if result.Answer != "4" || result.Score != 9 {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	req := provider.requests[0]
+	if req.Model != "gpt-4o" || req.MaxTokens != 200 {
+		t.Errorf("Expected config to be applied, got %+v", req)
+	}
+	if req.Messages[0].Content != "You are a math assistant." {
+		t.Errorf("Expected system prompt to be applied, got %+v", req.Messages[0])
+	}
+	if req.Schema == nil || req.Schema.Name != "structuredanswer" {
+		t.Errorf("Unexpected schema: %+v", req.Schema)
+	}
+}
+
+func TestStructured_PointerType(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{{ID: "1", Content: `{"answer":"4","score":9}`, FinishReason: "stop"}},
+	}
+
+	result, err := Structured[*structuredAnswer](context.Background(), provider, "2+2?")
+	if err != nil {
+		t.Fatalf("Structured failed: %v", err)
+	}
+	if result == nil || result.Answer != "4" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+}
+
+func TestStructured_InvalidType(t *testing.T) {
+	provider := &fakeProvider{}
+
+	_, err := Structured[int](context.Background(), provider, "2+2?")
+	if err == nil || !strings.Contains(err.Error(), "must be a struct") {
+		t.Errorf("Expected struct validation error, got %v", err)
+	}
+
+	_, err = Structured[map[string]string](context.Background(), provider, "2+2?")
+	if err == nil {
+		t.Error("Expected error for map type")
+	}
+
+	if len(provider.requests) != 0 {
+		t.Errorf("Expected no requests for invalid types, got %d", len(provider.requests))
+	}
+}
+
+func TestResponseSchemaFor_Cached(t *testing.T) {
+	first, err := responseSchemaFor(reflect.TypeOf(structuredAnswer{}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	second, err := responseSchemaFor(reflect.TypeOf(structuredAnswer{}))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	if first != second {
+		t.Error("Expected cached schema to be reused")
+	}
+}
+
+func TestStructuredQuery_InvalidTarget(t *testing.T) {
+	provider := &fakeProvider{}
+
+	tests := []struct {
+		name   string
+		target interface{}
+	}{
+		{"non-pointer", structuredAnswer{}},
+		{"nil pointer", (*structuredAnswer)(nil)},
+		{"nil", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := StructuredQuery(context.Background(), provider, DefaultConfig(), "2+2?", "System", tt.target)
+			if err == nil || !strings.Contains(err.Error(), "non-nil pointer") {
+				t.Errorf("Expected target validation error, got %v", err)
+			}
+		})
+	}
+}
+
+type validatedAnswer struct {
+	Answer string `json:"answer"`
+}
+
+func (a validatedAnswer) Validate() error {
+	if a.Answer == "" {
+		return errors.New("answer must not be empty")
+	}
+	return nil
+}
+
+func TestStructured_ValidationRepair(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{
+			{ID: "1", Content: `{"answer":""}`, FinishReason: "stop"},
+			{ID: "2", Content: `{"answer":"4"}`, FinishReason: "stop"},
+		},
+	}
+
+	result, err := Structured[validatedAnswer](context.Background(), provider, "2+2?")
+	if err != nil {
+		t.Fatalf("Structured failed: %v", err)
+	}
+	if result.Answer != "4" {
+		t.Errorf("Expected repaired answer '4', got '%s'", result.Answer)
+	}
+
+	if len(provider.requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(provider.requests))
+	}
+	repair := provider.requests[1].Messages
+	if len(repair) != 4 || repair[2].Role != "assistant" || !strings.Contains(repair[3].Content, "answer must not be empty") {
+		t.Errorf("Expected validation error to be sent back to the model, got %+v", repair)
+	}
+}
+
+func TestStructured_ValidationExhausted(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{
+			{ID: "1", Content: `{"answer":""}`, FinishReason: "stop"},
+			{ID: "2", Content: `{"answer":""}`, FinishReason: "stop"},
+		},
+	}
+
+	config := DefaultConfig()
+	config.ValidationRetries = 1
+
+	_, err := Structured[validatedAnswer](context.Background(), provider, "2+2?", WithConfig(config))
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+	if len(validationErr.Attempts) != 2 {
+		t.Errorf("Expected 2 attempts, got %d", len(validationErr.Attempts))
+	}
+	if len(provider.requests) != 2 {
+		t.Errorf("Expected 2 requests, got %d", len(provider.requests))
+	}
+}
+
+func TestStructuredQuery_ValidationPointerReceiver(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{{ID: "1", Content: `{"answer":""}`, FinishReason: "stop"}},
+	}
+
+	config := DefaultConfig()
+	config.ValidationRetries = 0
+
+	var result validatedAnswer
+	err := StructuredQuery(context.Background(), provider, config, "2+2?", "System", &result)
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("Expected ValidationError, got %v", err)
+	}
+}
+
+func TestStructured_TruncationRetry(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{
+			{ID: "1", Content: `{"answer":"fo`, FinishReason: "length"},
+			{ID: "2", Content: "", FinishReason: "length"},
+			{ID: "3", Content: `{"answer":"four","score":9}`, FinishReason: "stop"},
+		},
+	}
+
+	config := DefaultConfig()
+	config.MaxTokens = 100
+	config.TruncationMaxTokens = 300
+
+	result, err := Structured[structuredAnswer](context.Background(), provider, "2+2?", WithConfig(config))
+	if err != nil {
+		t.Fatalf("Structured failed: %v", err)
+	}
+	if result.Answer != "four" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	var budgets []int
+	for _, req := range provider.requests {
+		budgets = append(budgets, req.MaxTokens)
+	}
+	if !reflect.DeepEqual(budgets, []int{100, 200, 300}) {
+		t.Errorf("Expected token budgets [100 200 300], got %v", budgets)
+	}
+}
+
+func TestStructured_TruncationBudgetExhausted(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{
+			{ID: "1", Content: `{"answer":"fo`, FinishReason: "length"},
+			{ID: "2", Content: `{"answer":"fou`, FinishReason: "length"},
+		},
+	}
+
+	config := DefaultConfig()
+	config.MaxTokens = 100
+	config.TruncationMaxTokens = 200
+
+	_, err := Structured[structuredAnswer](context.Background(), provider, "2+2?", WithConfig(config))
+	if !errors.Is(err, ErrTruncated) {
+		t.Errorf("Expected ErrTruncated, got %v", err)
+	}
+	if len(provider.requests) != 2 {
+		t.Errorf("Expected 2 requests, got %d", len(provider.requests))
+	}
+}
+
+func TestStructured_Refusal(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{{ID: "1", Refusal: "I can't help with that.", FinishReason: "stop"}},
+	}
+
+	_, err := Structured[structuredAnswer](context.Background(), provider, "something bad")
+
+	var respErr *ResponseError
+	if !errors.As(err, &respErr) || !errors.Is(err, ErrRefusal) {
+		t.Fatalf("Expected refusal ResponseError, got %v", err)
+	}
+	if respErr.Refusal != "I can't help with that." {
+		t.Errorf("Expected refusal text, got %q", respErr.Refusal)
+	}
+	if len(provider.requests) != 1 {
+		t.Errorf("Expected refusals not to be retried, got %d requests", len(provider.requests))
+	}
+}
@@ -0,0 +1,428 @@
+package testutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	ai "github.com/bharathcs/go-ai-utils/lib"
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+// FakeResponse is a scripted reply from a FakeServer
+type FakeResponse struct {
+	Content string
+	// JSON, if set, is encoded and sent as the content, for structured queries
+	JSON      interface{}
+	Refusal   string
+	ToolCalls []ai.ToolCall
+	// FinishReason defaults to "tool_calls" when ToolCalls is set and "stop"
+	// otherwise
+	FinishReason string
+	// Usage defaults to an estimate from the request and reply
+	Usage ai.Usage
+	// Status, if set to an error status, fails the request with Error as the
+	// message
+	Status int
+	Error  string
+	// RetryAfter is sent in the Retry-After headers of an error reply
+	RetryAfter time.Duration
+	// Delay holds the reply back, or until the client gives up
+	Delay time.Duration
+}
+
+// FakeRequest is a chat completion request received by a FakeServer,
+// decoded back into the lib request it was built from
+type FakeRequest struct {
+	ai.Request
+	Stream bool
+	Header http.Header
+	// Body is the raw JSON request body
+	Body []byte
+}
+
+// FakeHandler builds the reply to a request
+type FakeHandler func(req *FakeRequest) FakeResponse
+
+// FakeServer is an in-process stand-in for the OpenAI chat completions API.
+// Replies are taken from the queue filled by Enqueue, then from the handler
+// set with HandleFunc. It supports structured outputs, tool calls, streaming
+// and error statuses. It is safe for concurrent use.
+type FakeServer struct {
+	// URL is the base URL to configure clients with
+	URL string
+
+	t        testing.TB
+	mu       sync.Mutex
+	queue    []FakeResponse
+	handler  FakeHandler
+	requests []*FakeRequest
+	ids      int
+}
+
+// NewFakeServer starts a FakeServer that is closed when the test finishes
+func NewFakeServer(t testing.TB) *FakeServer {
+	t.Helper()
+
+	s := &FakeServer{t: t}
+	server := httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(server.Close)
+	s.URL = server.URL + "/v1"
+	return s
+}
+
+// Enqueue adds replies to be sent, one per request, in order
+func (s *FakeServer) Enqueue(responses ...FakeResponse) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queue = append(s.queue, responses...)
+}
+
+// HandleFunc sets the handler that replies once the queue is empty
+func (s *FakeServer) HandleFunc(handler FakeHandler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handler = handler
+}
+
+// Requests returns the requests received so far
+func (s *FakeServer) Requests() []*FakeRequest {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]*FakeRequest(nil), s.requests...)
+}
+
+// Provider returns a Provider that sends requests to the server
+func (s *FakeServer) Provider() ai.Provider {
+	client := openai.NewClient(option.WithAPIKey("fake-key"), option.WithBaseURL(s.URL), option.WithMaxRetries(0))
+	return ai.NewOpenAIProvider(&client)
+}
+
+// UseEnv points OPENAI_BASE_URL at the server for the rest of the test, so
+// NewClientFromEnv and the *FromEnv helpers use it. OPENAI_API_KEY is set to
+// a placeholder and OPENAI_MODEL is cleared. Tests using it can't run in
+// parallel.
+func (s *FakeServer) UseEnv(t testing.TB) {
+	t.Setenv("OPENAI_BASE_URL", s.URL)
+	t.Setenv("OPENAI_API_KEY", "fake-key")
+	t.Setenv("OPENAI_MODEL", "")
+}
+
+func (s *FakeServer) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || !strings.HasSuffix(r.URL.Path, "/chat/completions") {
+		writeFakeError(w, http.StatusNotFound, "fake server only implements POST /chat/completions", 0)
+		return
+	}
+
+	req, err := decodeFakeRequest(r)
+	if err != nil {
+		writeFakeError(w, http.StatusBadRequest, err.Error(), 0)
+		return
+	}
+
+	s.mu.Lock()
+	s.requests = append(s.requests, req)
+	var resp FakeResponse
+	switch {
+	case len(s.queue) > 0:
+		resp, s.queue = s.queue[0], s.queue[1:]
+	case s.handler != nil:
+		handler := s.handler
+		s.mu.Unlock()
+		resp = handler(req)
+		s.mu.Lock()
+	default:
+		s.t.Errorf("fake server: no reply scripted for request %d", len(s.requests))
+		resp = FakeResponse{Status: http.StatusInternalServerError, Error: "no reply scripted"}
+	}
+	s.ids++
+	id := fmt.Sprintf("chatcmpl-fake-%d", s.ids)
+	s.mu.Unlock()
+
+	if resp.Delay > 0 {
+		select {
+		case <-time.After(resp.Delay):
+		case <-r.Context().Done():
+			return
+		}
+	}
+
+	if resp.Status != 0 && resp.Status != http.StatusOK {
+		writeFakeError(w, resp.Status, resp.Error, resp.RetryAfter)
+		return
+	}
+
+	if resp.JSON != nil {
+		data, err := json.Marshal(resp.JSON)
+		if err != nil {
+			s.t.Errorf("fake server: failed to encode JSON reply: %v", err)
+		}
+		resp.Content = string(data)
+	}
+	if resp.FinishReason == "" {
+		resp.FinishReason = "stop"
+		if len(resp.ToolCalls) > 0 {
+			resp.FinishReason = "tool_calls"
+		}
+	}
+	if resp.Usage == (ai.Usage{}) {
+		resp.Usage = estimateUsage(req, resp)
+	}
+
+	if req.Stream {
+		writeFakeStream(w, id, req, resp, includeUsage(req.Body))
+		return
+	}
+	writeFakeCompletion(w, id, req, resp)
+}
+
+// fakeChatRequest is the subset of the chat completions request the fake reads
+type fakeChatRequest struct {
+	Model    string `json:"model"`
+	Messages []struct {
+		Role       string          `json:"role"`
+		Content    json.RawMessage `json:"content"`
+		ToolCallID string          `json:"tool_call_id"`
+		ToolCalls  []fakeToolCall  `json:"tool_calls"`
+	} `json:"messages"`
+	MaxTokens           int      `json:"max_tokens"`
+	MaxCompletionTokens int      `json:"max_completion_tokens"`
+	Temperature         *float64 `json:"temperature"`
+	ReasoningEffort     string   `json:"reasoning_effort"`
+	ResponseFormat      *struct {
+		JSONSchema *struct {
+			Name   string                 `json:"name"`
+			Schema map[string]interface{} `json:"schema"`
+			Strict bool                   `json:"strict"`
+		} `json:"json_schema"`
+	} `json:"response_format"`
+	Tools []struct {
+		Function struct {
+			Name        string                 `json:"name"`
+			Description string                 `json:"description"`
+			Parameters  map[string]interface{} `json:"parameters"`
+		} `json:"function"`
+	} `json:"tools"`
+	Stream bool `json:"stream"`
+}
+
+type fakeToolCall struct {
+	Index    *int   `json:"index,omitempty"`
+	ID       string `json:"id"`
+	Type     string `json:"type"`
+	Function struct {
+		Name      string `json:"name"`
+		Arguments string `json:"arguments"`
+	} `json:"function"`
+}
+
+type fakeContentPart struct {
+	Type     string `json:"type"`
+	Text     string `json:"text"`
+	ImageURL struct {
+		URL    string `json:"url"`
+		Detail string `json:"detail"`
+	} `json:"image_url"`
+	File struct {
+		FileData string `json:"file_data"`
+		Filename string `json:"filename"`
+	} `json:"file"`
+}
+
+// decodeFakeRequest converts a chat completions request body to a FakeRequest
+func decodeFakeRequest(r *http.Request) (*FakeRequest, error) {
+	var body json.RawMessage
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+	var chat fakeChatRequest
+	if err := json.Unmarshal(body, &chat); err != nil {
+		return nil, fmt.Errorf("invalid request body: %w", err)
+	}
+
+	req := &FakeRequest{
+		Request: ai.Request{
+			Model:           chat.Model,
+			MaxTokens:       max(chat.MaxTokens, chat.MaxCompletionTokens),
+			Temperature:     chat.Temperature,
+			ReasoningEffort: chat.ReasoningEffort,
+		},
+		Stream: chat.Stream,
+		Header: r.Header.Clone(),
+		Body:   body,
+	}
+
+	for _, m := range chat.Messages {
+		msg := ai.Message{Role: m.Role, ToolCallID: m.ToolCallID}
+		for _, call := range m.ToolCalls {
+			msg.ToolCalls = append(msg.ToolCalls, ai.ToolCall{ID: call.ID, Name: call.Function.Name, Arguments: call.Function.Arguments})
+		}
+
+		var parts []fakeContentPart
+		if err := json.Unmarshal(m.Content, &msg.Content); err != nil && json.Unmarshal(m.Content, &parts) == nil {
+			// lib sends a multimodal message's text first, then its parts
+			if len(parts) > 0 && parts[0].Type == "text" {
+				msg.Content, parts = parts[0].Text, parts[1:]
+			}
+			for _, part := range parts {
+				switch part.Type {
+				case "image_url":
+					msg.Parts = append(msg.Parts, ai.ContentPart{Type: ai.PartImage, URL: part.ImageURL.URL, Detail: part.ImageURL.Detail})
+				case "file":
+					msg.Parts = append(msg.Parts, ai.ContentPart{Type: ai.PartFile, URL: part.File.FileData, Filename: part.File.Filename})
+				default:
+					msg.Parts = append(msg.Parts, ai.TextPart(part.Text))
+				}
+			}
+		}
+		req.Messages = append(req.Messages, msg)
+	}
+
+	if chat.ResponseFormat != nil && chat.ResponseFormat.JSONSchema != nil {
+		schema := chat.ResponseFormat.JSONSchema
+		req.Schema = &ai.ResponseSchema{Name: schema.Name, Schema: schema.Schema, Strict: schema.Strict}
+	}
+	for _, tool := range chat.Tools {
+		req.Tools = append(req.Tools, ai.ToolDefinition{Name: tool.Function.Name, Description: tool.Function.Description, Parameters: tool.Function.Parameters})
+	}
+	return req, nil
+}
+
+// includeUsage reports whether a streaming request asked for a usage chunk
+func includeUsage(body []byte) bool {
+	var options struct {
+		StreamOptions struct {
+			IncludeUsage bool `json:"include_usage"`
+		} `json:"stream_options"`
+	}
+	json.Unmarshal(body, &options)
+	return options.StreamOptions.IncludeUsage
+}
+
+// estimateUsage fills in token counts for replies without scripted usage
+func estimateUsage(req *FakeRequest, resp FakeResponse) ai.Usage {
+	chars := len(resp.Content) + len(resp.Refusal)
+	for _, call := range resp.ToolCalls {
+		chars += len(call.Name) + len(call.Arguments)
+	}
+	usage := ai.Usage{PromptTokens: int64(ai.EstimateTokens(req.Messages)), CompletionTokens: int64(chars/4 + 1)}
+	usage.TotalTokens = usage.PromptTokens + usage.CompletionTokens
+	return usage
+}
+
+// fakeUsage is the usage object sent by the API
+func fakeUsage(usage ai.Usage) map[string]interface{} {
+	return map[string]interface{}{
+		"prompt_tokens":             usage.PromptTokens,
+		"completion_tokens":         usage.CompletionTokens,
+		"total_tokens":              usage.TotalTokens,
+		"prompt_tokens_details":     map[string]interface{}{"cached_tokens": usage.CachedTokens},
+		"completion_tokens_details": map[string]interface{}{"reasoning_tokens": usage.ReasoningTokens},
+	}
+}
+
+// fakeToolCalls converts tool calls to the API format, with stream indexes
+// if indexed is set
+func fakeToolCalls(calls []ai.ToolCall, indexed bool) []fakeToolCall {
+	var result []fakeToolCall
+	for i, call := range calls {
+		c := fakeToolCall{ID: call.ID, Type: "function"}
+		c.Function.Name, c.Function.Arguments = call.Name, call.Arguments
+		if indexed {
+			c.Index = &i
+		}
+		result = append(result, c)
+	}
+	return result
+}
+
+func writeFakeCompletion(w http.ResponseWriter, id string, req *FakeRequest, resp FakeResponse) {
+	message := map[string]interface{}{"role": "assistant", "content": nilIfEmpty(resp.Content), "refusal": nilIfEmpty(resp.Refusal)}
+	if len(resp.ToolCalls) > 0 {
+		message["tool_calls"] = fakeToolCalls(resp.ToolCalls, false)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":      id,
+		"object":  "chat.completion",
+		"created": time.Now().Unix(),
+		"model":   req.Model,
+		"choices": []interface{}{map[string]interface{}{"index": 0, "message": message, "finish_reason": resp.FinishReason}},
+		"usage":   fakeUsage(resp.Usage),
+	})
+}
+
+// writeFakeStream sends the reply as server-sent events, a few words per chunk
+func writeFakeStream(w http.ResponseWriter, id string, req *FakeRequest, resp FakeResponse, withUsage bool) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	flusher, _ := w.(http.Flusher)
+	created := time.Now().Unix()
+
+	send := func(delta map[string]interface{}, finishReason interface{}, usage interface{}) {
+		chunk := map[string]interface{}{"id": id, "object": "chat.completion.chunk", "created": created, "model": req.Model}
+		if delta != nil {
+			chunk["choices"] = []interface{}{map[string]interface{}{"index": 0, "delta": delta, "finish_reason": finishReason}}
+		} else {
+			chunk["choices"] = []interface{}{}
+		}
+		if usage != nil {
+			chunk["usage"] = usage
+		}
+		data, _ := json.Marshal(chunk)
+		fmt.Fprintf(w, "data: %s\n\n", data)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	send(map[string]interface{}{"role": "assistant"}, nil, nil)
+	for _, word := range strings.SplitAfter(resp.Content, " ") {
+		if word != "" {
+			send(map[string]interface{}{"content": word}, nil, nil)
+		}
+	}
+	if resp.Refusal != "" {
+		send(map[string]interface{}{"refusal": resp.Refusal}, nil, nil)
+	}
+	if len(resp.ToolCalls) > 0 {
+		send(map[string]interface{}{"tool_calls": fakeToolCalls(resp.ToolCalls, true)}, nil, nil)
+	}
+	send(map[string]interface{}{}, resp.FinishReason, nil)
+	if withUsage {
+		send(nil, nil, fakeUsage(resp.Usage))
+	}
+	fmt.Fprint(w, "data: [DONE]\n\n")
+}
+
+// writeFakeError sends an API error, with Retry-After headers if retryAfter is set
+func writeFakeError(w http.ResponseWriter, status int, message string, retryAfter time.Duration) {
+	if message == "" {
+		message = http.StatusText(status)
+	}
+	if retryAfter > 0 {
+		w.Header().Set("Retry-After-Ms", strconv.FormatInt(retryAfter.Milliseconds(), 10))
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]interface{}{"message": message, "type": "fake_error", "code": nil},
+	})
+}
+
+func nilIfEmpty(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
@@ -0,0 +1,140 @@
+package testutil
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	ai "github.com/bharathcs/go-ai-utils/lib"
+)
+
+type answer struct {
+	Answer string `json:"answer"`
+	Score  int    `json:"score"`
+}
+
+func TestFakeServer_Structured(t *testing.T) {
+	fake := NewFakeServer(t)
+	fake.Enqueue(FakeResponse{JSON: answer{Answer: "4", Score: 9}})
+
+	config := ai.DefaultConfig()
+	result, err := ai.Structured[answer](context.Background(), fake.Provider(), "What is 2+2?", ai.WithConfig(config), ai.WithSystemPrompt("Math"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if result.Answer != "4" || result.Score != 9 {
+		t.Errorf("Expected the scripted answer, got %+v", result)
+	}
+
+	requests := fake.Requests()
+	if len(requests) != 1 {
+		t.Fatalf("Expected 1 request, got %d", len(requests))
+	}
+	req := requests[0]
+	if req.Model != config.Model || req.MaxTokens != config.MaxTokens || req.Stream {
+		t.Errorf("Unexpected request settings: %+v", req.Request)
+	}
+	if len(req.Messages) != 2 || req.Messages[1].Content != "What is 2+2?" {
+		t.Errorf("Unexpected messages: %+v", req.Messages)
+	}
+	if req.Schema == nil || req.Schema.Name != "answer" || !req.Schema.Strict {
+		t.Errorf("Expected the answer schema, got %+v", req.Schema)
+	}
+	if req.Header.Get("Authorization") != "Bearer fake-key" {
+		t.Errorf("Expected the fake API key, got %q", req.Header.Get("Authorization"))
+	}
+}
+
+func TestFakeServer_HandleFunc(t *testing.T) {
+	fake := NewFakeServer(t)
+	fake.Enqueue(FakeResponse{Content: "queued"})
+	fake.HandleFunc(func(req *FakeRequest) FakeResponse {
+		return FakeResponse{Content: "echo: " + req.Messages[len(req.Messages)-1].Content}
+	})
+
+	tests := []struct {
+		prompt   string
+		expected string
+	}{
+		{"first", "queued"},
+		{"second", "echo: second"},
+		{"third", "echo: third"},
+	}
+	for _, tt := range tests {
+		got, err := ai.QuickQuery(context.Background(), fake.Provider(), ai.DefaultConfig(), tt.prompt, "")
+		if err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if got != tt.expected {
+			t.Errorf("Expected %q, got %q", tt.expected, got)
+		}
+	}
+}
+
+func TestFakeServer_Attachments(t *testing.T) {
+	fake := NewFakeServer(t)
+	fake.Enqueue(FakeResponse{Content: "a cat"})
+
+	image := ai.ImageURL("https://example.com/cat.png")
+	file := ai.FileData("notes.pdf", []byte("%PDF-1.4"), "application/pdf")
+	if _, err := ai.QuickQueryWithAttachments(context.Background(), fake.Provider(), ai.DefaultConfig(), "What is this?", "", image, file); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	msg := fake.Requests()[0].Messages[1]
+	if msg.Content != "What is this?" || len(msg.Parts) != 2 {
+		t.Fatalf("Expected the prompt and two parts, got %+v", msg)
+	}
+	if msg.Parts[0] != image || msg.Parts[1] != file {
+		t.Errorf("Expected the attachments back, got %+v", msg.Parts)
+	}
+}
+
+func TestFakeServer_Errors(t *testing.T) {
+	tests := []struct {
+		status int
+		check  func(err error) bool
+	}{
+		{http.StatusUnauthorized, func(err error) bool { var e *ai.AuthError; return errors.As(err, &e) }},
+		{http.StatusTooManyRequests, func(err error) bool { var e *ai.RateLimitError; return errors.As(err, &e) }},
+		{http.StatusServiceUnavailable, func(err error) bool { var e *ai.ServerError; return errors.As(err, &e) }},
+	}
+
+	for _, tt := range tests {
+		fake := NewFakeServer(t)
+		fake.Enqueue(FakeResponse{Status: tt.status, Error: "scripted failure"})
+
+		config := ai.DefaultConfig()
+		config.Retry = ai.RetryPolicy{MaxAttempts: 1}
+		_, err := ai.QuickQuery(context.Background(), fake.Provider(), config, "hi", "")
+		if !tt.check(err) || !strings.Contains(err.Error(), "scripted failure") {
+			t.Errorf("Unexpected error for status %d: %v", tt.status, err)
+		}
+	}
+}
+
+func TestFakeServer_Delay(t *testing.T) {
+	fake := NewFakeServer(t)
+	fake.Enqueue(FakeResponse{Content: "late", Delay: time.Second})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err := ai.QuickQuery(ctx, fake.Provider(), ai.DefaultConfig(), "hi", "")
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected context.DeadlineExceeded, got %v", err)
+	}
+}
+
+func TestFakeServer_UseEnv(t *testing.T) {
+	fake := NewFakeServer(t)
+	fake.UseEnv(t)
+	fake.Enqueue(FakeResponse{Content: "from env"})
+
+	got, err := ai.QuickQueryFromEnv(context.Background(), "hi", "")
+	if err != nil || got != "from env" {
+		t.Errorf("Expected the scripted reply, got %q, %v", got, err)
+	}
+}
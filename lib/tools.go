@@ -0,0 +1,113 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"regexp"
+)
+
+// ToolCall is a request from the model to run a registered tool
+type ToolCall struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+	// Arguments is the JSON-encoded argument object
+	Arguments string `json:"arguments"`
+}
+
+// ToolDefinition describes a tool offered to the model
+type ToolDefinition struct {
+	Name        string
+	Description string
+	Parameters  map[string]interface{}
+}
+
+// ToolRegistry holds the Go functions a model may call. Register tools before
+// the registry is used by a conversation.
+type ToolRegistry struct {
+	tools map[string]*registeredTool
+	order []string
+}
+
+type registeredTool struct {
+	definition ToolDefinition
+	call       func(ctx context.Context, arguments string) (string, error)
+}
+
+// NewToolRegistry creates an empty tool registry
+func NewToolRegistry() *ToolRegistry {
+	return &ToolRegistry{tools: map[string]*registeredTool{}}
+}
+
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// RegisterTool adds fn to r as a tool called name. Args must be a struct
+// whose JSON schema describes the tool's parameters. The result is sent to
+// the model as-is if it is a string and JSON-encoded otherwise.
+func RegisterTool[Args any, Result any](r *ToolRegistry, name, description string, fn func(ctx context.Context, args Args) (Result, error)) error {
+	if !toolNamePattern.MatchString(name) {
+		return fmt.Errorf("invalid tool name %q: must be 1-64 letters, digits, underscores or dashes", name)
+	}
+	if _, exists := r.tools[name]; exists {
+		return fmt.Errorf("tool %q is already registered", name)
+	}
+
+	schema, err := generateJSONSchema((*Args)(nil))
+	if err != nil {
+		return fmt.Errorf("failed to generate schema for tool %q: %w", name, err)
+	}
+
+	r.tools[name] = &registeredTool{
+		definition: ToolDefinition{Name: name, Description: description, Parameters: schema},
+		call: func(ctx context.Context, arguments string) (string, error) {
+			var args Args
+			if err := json.Unmarshal([]byte(arguments), &args); err != nil {
+				return "", fmt.Errorf("invalid arguments: %w", err)
+			}
+
+			result, err := fn(ctx, args)
+			if err != nil {
+				return "", err
+			}
+
+			if s, ok := any(result).(string); ok {
+				return s, nil
+			}
+			data, err := json.Marshal(result)
+			if err != nil {
+				return "", fmt.Errorf("failed to encode result: %w", err)
+			}
+			return string(data), nil
+		},
+	}
+	r.order = append(r.order, name)
+	return nil
+}
+
+// Definitions returns the registered tools in registration order
+func (r *ToolRegistry) Definitions() []ToolDefinition {
+	if r == nil {
+		return nil
+	}
+
+	definitions := make([]ToolDefinition, 0, len(r.order))
+	for _, name := range r.order {
+		definitions = append(definitions, r.tools[name].definition)
+	}
+	return definitions
+}
+
+// run executes call and returns the content of the tool message to send back.
+// Failures are reported to the model as text so it can correct itself.
+func (r *ToolRegistry) run(ctx context.Context, call ToolCall) string {
+	tool, ok := r.tools[call.Name]
+	if !ok {
+		return fmt.Sprintf("Error: unknown tool %q", call.Name)
+	}
+
+	result, err := tool.call(ctx, call.Arguments)
+	if err != nil {
+		return fmt.Sprintf("Error: %v", err)
+	}
+	return result
+}
@@ -0,0 +1,208 @@
+package lib
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+type addArgs struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+func newAddRegistry(t *testing.T) *ToolRegistry {
+	t.Helper()
+	registry := NewToolRegistry()
+	err := RegisterTool(registry, "add", "Adds two numbers", func(ctx context.Context, args addArgs) (int, error) {
+		if args.A < 0 {
+			return 0, errors.New("negative numbers are not supported")
+		}
+		return args.A + args.B, nil
+	})
+	if err != nil {
+		t.Fatalf("RegisterTool failed: %v", err)
+	}
+	return registry
+}
+
+func TestRegisterTool(t *testing.T) {
+	registry := newAddRegistry(t)
+
+	definitions := registry.Definitions()
+	if len(definitions) != 1 || definitions[0].Name != "add" || definitions[0].Description != "Adds two numbers" {
+		t.Fatalf("Unexpected definitions: %+v", definitions)
+	}
+	properties, ok := definitions[0].Parameters["properties"].(map[string]interface{})
+	if !ok || len(properties) != 2 {
+		t.Errorf("Expected schema with 2 properties, got %v", definitions[0].Parameters)
+	}
+
+	noop := func(ctx context.Context, args addArgs) (string, error) { return "", nil }
+	if err := RegisterTool(registry, "add", "", noop); err == nil {
+		t.Error("Expected error registering a duplicate tool")
+	}
+	if err := RegisterTool(registry, "bad name", "", noop); err == nil {
+		t.Error("Expected error for an invalid tool name")
+	}
+	if err := RegisterTool(registry, "scalar", "", func(ctx context.Context, args int) (string, error) { return "", nil }); err == nil {
+		t.Error("Expected error for non-struct arguments")
+	}
+}
+
+func TestToolRegistry_Run(t *testing.T) {
+	registry := newAddRegistry(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name string
+		call ToolCall
+		want string
+	}{
+		{"success", ToolCall{Name: "add", Arguments: `{"a":2,"b":3}`}, "5"},
+		{"tool error", ToolCall{Name: "add", Arguments: `{"a":-1,"b":3}`}, "Error: negative numbers are not supported"},
+		{"bad arguments", ToolCall{Name: "add", Arguments: `{"a":"x"}`}, "Error: invalid arguments"},
+		{"unknown tool", ToolCall{Name: "sub", Arguments: `{}`}, `Error: unknown tool "sub"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := registry.run(ctx, tt.call); !strings.HasPrefix(got, tt.want) {
+				t.Errorf("Expected result starting with %q, got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestConversation_ToolLoop(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{
+			{ID: "1", FinishReason: "tool_calls", ToolCalls: []ToolCall{
+				{ID: "call_1", Name: "add", Arguments: `{"a":2,"b":3}`},
+				{ID: "call_2", Name: "add", Arguments: `{"a":-1,"b":1}`},
+			}, Usage: Usage{TotalTokens: 10}},
+			{ID: "2", Content: "2+3 is 5", FinishReason: "stop", Usage: Usage{TotalTokens: 20}},
+		},
+	}
+
+	conv := NewConversation(provider, DefaultConfig(), "System")
+	conv.SetTools(newAddRegistry(t))
+
+	reply, err := conv.SendMessage(context.Background(), "What is 2+3?")
+	if err != nil {
+		t.Fatalf("SendMessage failed: %v", err)
+	}
+	if reply != "2+3 is 5" {
+		t.Errorf("Expected final answer, got %q", reply)
+	}
+
+	if len(provider.requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(provider.requests))
+	}
+	if len(provider.requests[0].Tools) != 1 {
+		t.Errorf("Expected tools to be offered, got %+v", provider.requests[0].Tools)
+	}
+
+	// system, user, assistant tool calls, two tool results
+	second := provider.requests[1].Messages
+	if len(second) != 5 {
+		t.Fatalf("Expected 5 messages in follow-up request, got %+v", second)
+	}
+	if second[2].Role != "assistant" || len(second[2].ToolCalls) != 2 {
+		t.Errorf("Expected assistant tool call message, got %+v", second[2])
+	}
+	if second[3].Role != "tool" || second[3].ToolCallID != "call_1" || second[3].Content != "5" {
+		t.Errorf("Unexpected tool result: %+v", second[3])
+	}
+	if second[4].ToolCallID != "call_2" || !strings.HasPrefix(second[4].Content, "Error:") {
+		t.Errorf("Expected tool error to be fed back, got %+v", second[4])
+	}
+
+	if history := conv.GetHistory(); len(history) != 3 || history[2].Content != "2+3 is 5" {
+		t.Errorf("Expected history without tool messages, got %+v", history)
+	}
+	if len(conv.messages) != 6 {
+		t.Errorf("Expected tool exchange kept in provider context, got %d messages", len(conv.messages))
+	}
+	if conv.Usage().TotalTokens != 30 {
+		t.Errorf("Expected 30 total tokens, got %d", conv.Usage().TotalTokens)
+	}
+}
+
+func TestConversation_ToolLoop_MaxSteps(t *testing.T) {
+	call := &Response{ID: "1", FinishReason: "tool_calls", ToolCalls: []ToolCall{{ID: "call", Name: "add", Arguments: `{"a":1,"b":1}`}}}
+	provider := &fakeProvider{responses: []*Response{call, call, call}}
+
+	config := DefaultConfig()
+	config.MaxToolSteps = 2
+	conv := NewConversation(provider, config, "System")
+	conv.SetTools(newAddRegistry(t))
+
+	_, err := conv.SendMessage(context.Background(), "loop")
+	if !errors.Is(err, ErrMaxToolSteps) {
+		t.Fatalf("Expected ErrMaxToolSteps, got %v", err)
+	}
+	if len(provider.requests) != 3 {
+		t.Errorf("Expected 3 requests, got %d", len(provider.requests))
+	}
+	if len(conv.GetHistory()) != 1 || len(conv.messages) != 1 {
+		t.Error("Expected conversation to be unchanged after hitting the tool limit")
+	}
+}
+
+func TestOpenAIProvider_ToolCalls(t *testing.T) {
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		json.Unmarshal(data, &body)
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"chatcmpl-4","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"finish_reason":"tool_calls","message":{"role":"assistant","content":null,"tool_calls":[{"id":"call_9","type":"function","function":{"name":"add","arguments":"{\"a\":1,\"b\":2}"}}]}}]}`)
+	}))
+	defer server.Close()
+
+	client := openai.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL))
+	resp, err := NewOpenAIProvider(&client).Complete(context.Background(), &Request{
+		Model:     "gpt-4o",
+		MaxTokens: 100,
+		Messages: []Message{
+			{Role: "user", Content: "1+2?"},
+			{Role: "assistant", ToolCalls: []ToolCall{{ID: "call_0", Name: "add", Arguments: `{"a":0,"b":0}`}}},
+			{Role: "tool", Content: "0", ToolCallID: "call_0"},
+		},
+		Tools: newAddRegistry(t).Definitions(),
+	})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	if len(resp.ToolCalls) != 1 || resp.ToolCalls[0].ID != "call_9" || resp.ToolCalls[0].Name != "add" || resp.ToolCalls[0].Arguments != `{"a":1,"b":2}` {
+		t.Errorf("Unexpected tool calls: %+v", resp.ToolCalls)
+	}
+
+	tools, _ := body["tools"].([]interface{})
+	if len(tools) != 1 {
+		t.Fatalf("Expected 1 tool in request, got %v", body["tools"])
+	}
+	function := tools[0].(map[string]interface{})["function"].(map[string]interface{})
+	if function["name"] != "add" || function["strict"] != true {
+		t.Errorf("Unexpected tool definition: %v", function)
+	}
+
+	messages := body["messages"].([]interface{})
+	assistant := messages[1].(map[string]interface{})
+	if calls, _ := assistant["tool_calls"].([]interface{}); len(calls) != 1 {
+		t.Errorf("Expected assistant tool calls in request, got %v", assistant)
+	}
+	tool := messages[2].(map[string]interface{})
+	if tool["role"] != "tool" || tool["tool_call_id"] != "call_0" {
+		t.Errorf("Expected tool message in request, got %v", tool)
+	}
+}
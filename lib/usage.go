@@ -0,0 +1,183 @@
+package lib
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+// Usage reports the tokens consumed by one or more completion calls
+type Usage struct {
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	TotalTokens      int64 `json:"total_tokens"`
+	// CachedTokens is the part of PromptTokens served from the prompt cache
+	CachedTokens int64 `json:"cached_tokens,omitempty"`
+	// ReasoningTokens is the part of CompletionTokens spent on hidden reasoning
+	ReasoningTokens int64 `json:"reasoning_tokens,omitempty"`
+	// CacheHits counts responses served from Config.Cache, which use no tokens
+	CacheHits int64 `json:"cache_hits,omitempty"`
+}
+
+// Add returns the sum of u and other
+func (u Usage) Add(other Usage) Usage {
+	return Usage{
+		PromptTokens:     u.PromptTokens + other.PromptTokens,
+		CompletionTokens: u.CompletionTokens + other.CompletionTokens,
+		TotalTokens:      u.TotalTokens + other.TotalTokens,
+		CachedTokens:     u.CachedTokens + other.CachedTokens,
+		ReasoningTokens:  u.ReasoningTokens + other.ReasoningTokens,
+		CacheHits:        u.CacheHits + other.CacheHits,
+	}
+}
+
+// ModelPrice is the price in USD per million tokens for a model
+type ModelPrice struct {
+	Input       float64
+	CachedInput float64
+	Output      float64
+}
+
+// PriceTable maps model names to prices. Lookups fall back to the longest
+// matching prefix, so "gpt-4o" also prices dated snapshots like "gpt-4o-2024-08-06".
+type PriceTable map[string]ModelPrice
+
+// DefaultPriceTable returns published OpenAI list prices for common models.
+// Prices change; treat costs computed from it as estimates.
+func DefaultPriceTable() PriceTable {
+	return PriceTable{
+		"gpt-5":        {Input: 1.25, CachedInput: 0.125, Output: 10},
+		"gpt-5-mini":   {Input: 0.25, CachedInput: 0.025, Output: 2},
+		"gpt-5-nano":   {Input: 0.05, CachedInput: 0.005, Output: 0.4},
+		"gpt-4.1":      {Input: 2, CachedInput: 0.5, Output: 8},
+		"gpt-4.1-mini": {Input: 0.4, CachedInput: 0.1, Output: 1.6},
+		"gpt-4.1-nano": {Input: 0.1, CachedInput: 0.025, Output: 0.4},
+		"gpt-4o":       {Input: 2.5, CachedInput: 1.25, Output: 10},
+		"gpt-4o-mini":  {Input: 0.15, CachedInput: 0.075, Output: 0.6},
+		"o3":           {Input: 2, CachedInput: 0.5, Output: 8},
+		"o4-mini":      {Input: 1.1, CachedInput: 0.275, Output: 4.4},
+	}
+}
+
+// Lookup returns the price for model, matching exactly or by longest prefix
+func (p PriceTable) Lookup(model string) (ModelPrice, bool) {
+	if price, ok := p[model]; ok {
+		return price, true
+	}
+
+	best := ""
+	for name := range p {
+		if strings.HasPrefix(model, name) && len(name) > len(best) {
+			best = name
+		}
+	}
+	if best == "" {
+		return ModelPrice{}, false
+	}
+	return p[best], true
+}
+
+// Cost estimates the USD cost of usage on model. It returns false if the
+// model has no price.
+func (p PriceTable) Cost(model string, usage Usage) (float64, bool) {
+	price, ok := p.Lookup(model)
+	if !ok {
+		return 0, false
+	}
+
+	uncached := usage.PromptTokens - usage.CachedTokens
+	cost := float64(uncached)*price.Input +
+		float64(usage.CachedTokens)*price.CachedInput +
+		float64(usage.CompletionTokens)*price.Output
+	return cost / 1_000_000, true
+}
+
+// UsageMeter accumulates usage per model. It is safe for concurrent use.
+type UsageMeter struct {
+	mu      sync.Mutex
+	byModel map[string]Usage
+}
+
+// NewUsageMeter creates an empty usage meter
+func NewUsageMeter() *UsageMeter {
+	return &UsageMeter{byModel: map[string]Usage{}}
+}
+
+// Add records usage for model
+func (m *UsageMeter) Add(model string, usage Usage) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.byModel[model] = m.byModel[model].Add(usage)
+}
+
+// Total returns the usage summed across all models
+func (m *UsageMeter) Total() Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var total Usage
+	for _, usage := range m.byModel {
+		total = total.Add(usage)
+	}
+	return total
+}
+
+// ByModel returns a copy of the usage recorded for each model
+func (m *UsageMeter) ByModel() map[string]Usage {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	result := make(map[string]Usage, len(m.byModel))
+	for model, usage := range m.byModel {
+		result[model] = usage
+	}
+	return result
+}
+
+// Cost estimates the total USD cost of the recorded usage. Models missing
+// from prices are skipped and returned so callers can tell the total is partial.
+func (m *UsageMeter) Cost(prices PriceTable) (float64, []string) {
+	var total float64
+	var unpriced []string
+	for model, usage := range m.ByModel() {
+		cost, ok := prices.Cost(model, usage)
+		if !ok {
+			unpriced = append(unpriced, model)
+			continue
+		}
+		total += cost
+	}
+	return total, unpriced
+}
+
+// Reset clears the recorded usage
+func (m *UsageMeter) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.byModel = map[string]Usage{}
+}
+
+// globalUsage records the usage of every call made through lib in this process
+var globalUsage = NewUsageMeter()
+
+// GlobalUsage returns the process-wide usage meter
+func GlobalUsage() *UsageMeter {
+	return globalUsage
+}
+
+type usageMeterKey struct{}
+
+// WithUsageMeter returns a context whose calls also record usage into meter,
+// in addition to the process-wide meter. Use it to scope accounting to a
+// session or batch job.
+func WithUsageMeter(ctx context.Context, meter *UsageMeter) context.Context {
+	return context.WithValue(ctx, usageMeterKey{}, meter)
+}
+
+// recordUsage adds usage to the process-wide meter and any meter on ctx
+func recordUsage(ctx context.Context, model string, usage Usage) {
+	globalUsage.Add(model, usage)
+	if meter, ok := ctx.Value(usageMeterKey{}).(*UsageMeter); ok && meter != nil {
+		meter.Add(model, usage)
+	}
+}
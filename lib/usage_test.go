@@ -0,0 +1,161 @@
+package lib
+
+import (
+	"context"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/openai/openai-go"
+	"github.com/openai/openai-go/option"
+)
+
+func TestUsage_Add(t *testing.T) {
+	a := Usage{PromptTokens: 10, CompletionTokens: 5, TotalTokens: 15, CachedTokens: 2, ReasoningTokens: 1}
+	b := Usage{PromptTokens: 1, CompletionTokens: 2, TotalTokens: 3, CachedTokens: 1, ReasoningTokens: 2}
+
+	got := a.Add(b)
+	want := Usage{PromptTokens: 11, CompletionTokens: 7, TotalTokens: 18, CachedTokens: 3, ReasoningTokens: 3}
+	if got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
+func TestPriceTable_Lookup(t *testing.T) {
+	prices := PriceTable{
+		"gpt-4o":      {Input: 2.5},
+		"gpt-4o-mini": {Input: 0.15},
+	}
+
+	tests := []struct {
+		model string
+		input float64
+		ok    bool
+	}{
+		{"gpt-4o", 2.5, true},
+		{"gpt-4o-mini", 0.15, true},
+		{"gpt-4o-2024-08-06", 2.5, true},
+		{"gpt-4o-mini-2024-07-18", 0.15, true},
+		{"claude", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.model, func(t *testing.T) {
+			price, ok := prices.Lookup(tt.model)
+			if ok != tt.ok || price.Input != tt.input {
+				t.Errorf("Expected (%v, %v), got (%v, %v)", tt.input, tt.ok, price.Input, ok)
+			}
+		})
+	}
+}
+
+func TestPriceTable_Cost(t *testing.T) {
+	prices := PriceTable{"m": {Input: 2, CachedInput: 1, Output: 10}}
+	usage := Usage{PromptTokens: 1_000_000, CachedTokens: 500_000, CompletionTokens: 100_000}
+
+	cost, ok := prices.Cost("m", usage)
+	if !ok {
+		t.Fatal("Expected model to be priced")
+	}
+	// 0.5M uncached * 2 + 0.5M cached * 1 + 0.1M output * 10
+	if math.Abs(cost-2.5) > 1e-9 {
+		t.Errorf("Expected cost 2.5, got %v", cost)
+	}
+
+	if _, ok := prices.Cost("other", usage); ok {
+		t.Error("Expected unknown model to be unpriced")
+	}
+}
+
+func TestUsageMeter(t *testing.T) {
+	meter := NewUsageMeter()
+	meter.Add("a", Usage{PromptTokens: 1_000_000, TotalTokens: 1_000_000})
+	meter.Add("a", Usage{CompletionTokens: 1_000_000, TotalTokens: 1_000_000})
+	meter.Add("b", Usage{PromptTokens: 10, TotalTokens: 10})
+
+	if total := meter.Total(); total.TotalTokens != 2_000_010 {
+		t.Errorf("Expected 2000010 total tokens, got %d", total.TotalTokens)
+	}
+	if got := meter.ByModel()["a"]; got.PromptTokens != 1_000_000 || got.CompletionTokens != 1_000_000 {
+		t.Errorf("Unexpected usage for model a: %+v", got)
+	}
+
+	cost, unpriced := meter.Cost(PriceTable{"a": {Input: 1, Output: 2}})
+	if math.Abs(cost-3) > 1e-9 {
+		t.Errorf("Expected cost 3, got %v", cost)
+	}
+	if len(unpriced) != 1 || unpriced[0] != "b" {
+		t.Errorf("Expected unpriced [b], got %v", unpriced)
+	}
+
+	meter.Reset()
+	if total := meter.Total(); total != (Usage{}) {
+		t.Errorf("Expected empty usage after reset, got %+v", total)
+	}
+}
+
+func TestUsage_RecordedPerCall(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{
+			{ID: "1", Content: "one", FinishReason: "stop", Usage: Usage{PromptTokens: 3, CompletionTokens: 2, TotalTokens: 5}},
+			{ID: "2", Content: "two", FinishReason: "stop", Usage: Usage{PromptTokens: 7, CompletionTokens: 1, TotalTokens: 8}},
+		},
+	}
+	meter := NewUsageMeter()
+	ctx := WithUsageMeter(context.Background(), meter)
+
+	conv := NewConversation(provider, DefaultConfig(), "")
+	for _, msg := range []string{"a", "b"} {
+		if _, err := conv.SendMessage(ctx, msg); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+	}
+
+	want := Usage{PromptTokens: 10, CompletionTokens: 3, TotalTokens: 13}
+	if got := conv.Usage(); got != want {
+		t.Errorf("Expected conversation usage %+v, got %+v", want, got)
+	}
+	if got := meter.ByModel()["gpt-5-mini"]; got != want {
+		t.Errorf("Expected meter usage %+v, got %+v", want, got)
+	}
+}
+
+func TestStructured_WithUsage(t *testing.T) {
+	provider := &fakeProvider{
+		responses: []*Response{
+			{ID: "1", Content: `{"answer":"4","score":`, FinishReason: "length", Usage: Usage{TotalTokens: 100}},
+			{ID: "2", Content: `{"answer":"4","score":9}`, FinishReason: "stop", Usage: Usage{TotalTokens: 150}},
+		},
+	}
+
+	var usage Usage
+	_, err := Structured[structuredAnswer](context.Background(), provider, "2+2?", WithUsage(&usage))
+	if err != nil {
+		t.Fatalf("Structured failed: %v", err)
+	}
+
+	if usage.TotalTokens != 250 {
+		t.Errorf("Expected 250 total tokens across attempts, got %d", usage.TotalTokens)
+	}
+}
+
+func TestOpenAIProvider_Usage(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		io.WriteString(w, `{"id":"chatcmpl-1","object":"chat.completion","model":"gpt-4o","choices":[{"index":0,"finish_reason":"stop","message":{"role":"assistant","content":"hi"}}],"usage":{"prompt_tokens":20,"completion_tokens":10,"total_tokens":30,"prompt_tokens_details":{"cached_tokens":8},"completion_tokens_details":{"reasoning_tokens":4}}}`)
+	}))
+	defer server.Close()
+
+	client := openai.NewClient(option.WithAPIKey("test-key"), option.WithBaseURL(server.URL))
+	resp, err := NewOpenAIProvider(&client).Complete(context.Background(), &Request{Model: "gpt-4o", MaxTokens: 10})
+	if err != nil {
+		t.Fatalf("Complete failed: %v", err)
+	}
+
+	want := Usage{PromptTokens: 20, CompletionTokens: 10, TotalTokens: 30, CachedTokens: 8, ReasoningTokens: 4}
+	if resp.Usage != want {
+		t.Errorf("Expected usage %+v, got %+v", want, resp.Usage)
+	}
+}
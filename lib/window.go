@@ -0,0 +1,232 @@
+package lib
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"sync"
+)
+
+// ContextStrategy decides which messages are sent to the provider, so long
+// conversations stay within the model's context window. Strategies only trim
+// what is sent; the conversation itself keeps every turn. A leading system
+// message is always preserved, and turns (a user message with any tool calls
+// and replies that follow it) are never split. Trim returns the usage of any
+// completions it made, such as a summary, so it counts towards the turn.
+type ContextStrategy interface {
+	Trim(ctx context.Context, req *Request) ([]Message, Usage, error)
+}
+
+// ForkableStrategy is a ContextStrategy holding per-conversation state.
+// Conversation.Fork calls Fork to give the new conversation its own copy;
+// strategies that don't implement it are shared.
+type ForkableStrategy interface {
+	ContextStrategy
+	Fork() ContextStrategy
+}
+
+// splitTurns separates a leading system message from the turns that follow.
+// Each turn starts with a user message.
+func splitTurns(messages []Message) (system []Message, turns [][]Message) {
+	if len(messages) > 0 && messages[0].Role == "system" {
+		system, messages = messages[:1], messages[1:]
+	}
+
+	for i, msg := range messages {
+		if msg.Role == "user" || i == 0 {
+			turns = append(turns, nil)
+		}
+		turns[len(turns)-1] = append(turns[len(turns)-1], msg)
+	}
+	return system, turns
+}
+
+// joinTurns is the inverse of splitTurns
+func joinTurns(system []Message, turns [][]Message) []Message {
+	messages := append([]Message(nil), system...)
+	for _, turn := range turns {
+		messages = append(messages, turn...)
+	}
+	return messages
+}
+
+// EstimateTokens approximates the number of prompt tokens messages will use,
+// at about four characters per token plus a small per-message overhead, and
+// a flat imageTokens for each image or file part. It avoids a tokenizer
+// dependency and is only meant for budgeting.
+func EstimateTokens(messages []Message) int {
+	tokens := 3 // every reply is primed with an assistant header
+	for _, msg := range messages {
+		chars := len(msg.Role) + len(msg.Content) + len(msg.ToolCallID)
+		for _, call := range msg.ToolCalls {
+			chars += len(call.ID) + len(call.Name) + len(call.Arguments)
+		}
+		for _, part := range msg.Parts {
+			if part.Type == PartText {
+				chars += len(part.Text)
+			} else {
+				tokens += imageTokens
+			}
+		}
+		tokens += 4 + (chars+3)/4
+	}
+	return tokens
+}
+
+// imageTokens is the estimate for one image or file part, matching a
+// high-detail 512px image
+const imageTokens = 765
+
+// SlidingWindow keeps only the most recent MaxTurns turns, including the one
+// being sent
+type SlidingWindow struct {
+	MaxTurns int
+}
+
+// Trim implements ContextStrategy
+func (s SlidingWindow) Trim(ctx context.Context, req *Request) ([]Message, Usage, error) {
+	system, turns := splitTurns(req.Messages)
+	keep := max(s.MaxTurns, 1)
+	if len(turns) <= keep {
+		return req.Messages, Usage{}, nil
+	}
+	return joinTurns(system, turns[len(turns)-keep:]), Usage{}, nil
+}
+
+// TokenBudget drops the oldest turns until the estimated prompt fits in
+// MaxTokens. If MaxTokens is zero, the budget is the model's registered
+// context window minus the request's output token limit; models without a
+// known context window are not trimmed. The turn being sent is always kept.
+type TokenBudget struct {
+	MaxTokens int
+}
+
+// Trim implements ContextStrategy
+func (b TokenBudget) Trim(ctx context.Context, req *Request) ([]Message, Usage, error) {
+	budget := b.MaxTokens
+	if budget == 0 {
+		info, _ := LookupModel(req.Model)
+		if info.ContextWindow == 0 {
+			return req.Messages, Usage{}, nil
+		}
+		budget = info.ContextWindow - req.MaxTokens
+	}
+
+	system, turns := splitTurns(req.Messages)
+	for len(turns) > 1 && EstimateTokens(joinTurns(system, turns)) > budget {
+		turns = turns[1:]
+	}
+	return joinTurns(system, turns), Usage{}, nil
+}
+
+// Summarizer replaces older turns with a model-written summary. Once more
+// than MaxTurns turns would be sent, all but the most recent KeepTurns are
+// summarised and sent as a system message instead. Summaries are cached and
+// extended incrementally, so the model is only asked again once the window
+// fills up. A Summarizer holds state and should be used by one conversation;
+// Conversation.Fork gives the fork its own copy.
+type Summarizer struct {
+	client    Provider
+	config    *Config
+	maxTurns  int
+	keepTurns int
+
+	mu sync.Mutex
+	// summarised is the number of turns covered by summary, and prefix the
+	// fingerprint of those turns
+	summarised int
+	prefix     string
+	summary    string
+}
+
+// NewSummarizer creates a Summarizer that asks client (using config) for
+// summaries. keepTurns is clamped to at least 1 and at most maxTurns.
+func NewSummarizer(client Provider, config *Config, maxTurns, keepTurns int) *Summarizer {
+	maxTurns = max(maxTurns, 1)
+	keepTurns = min(max(keepTurns, 1), maxTurns)
+	return &Summarizer{client: client, config: config, maxTurns: maxTurns, keepTurns: keepTurns}
+}
+
+// Trim implements ContextStrategy
+func (s *Summarizer) Trim(ctx context.Context, req *Request) ([]Message, Usage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	system, turns := splitTurns(req.Messages)
+
+	// Start over if the conversation no longer begins with the summarised turns
+	if s.summarised > len(turns) || turnsFingerprint(turns[:s.summarised]) != s.prefix {
+		s.summarised, s.prefix, s.summary = 0, turnsFingerprint(nil), ""
+	}
+
+	var usage Usage
+	if len(turns)-s.summarised > s.maxTurns {
+		end := len(turns) - s.keepTurns
+		summary, summaryUsage, err := s.summarize(ctx, turns[s.summarised:end])
+		usage = summaryUsage
+		if err != nil {
+			return nil, usage, fmt.Errorf("failed to summarise conversation: %w", err)
+		}
+		s.summarised, s.prefix, s.summary = end, turnsFingerprint(turns[:end]), summary
+	}
+
+	if s.summarised == 0 {
+		return req.Messages, usage, nil
+	}
+	messages := append([]Message(nil), system...)
+	messages = append(messages, Message{Role: "system", Content: "Summary of the earlier conversation:\n" + s.summary})
+	return append(messages, joinTurns(nil, turns[s.summarised:])...), usage, nil
+}
+
+// Fork implements ForkableStrategy. The copy starts from the current
+// summary, so a fork only asks for a new one once its own window fills.
+func (s *Summarizer) Fork() ContextStrategy {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return &Summarizer{
+		client:     s.client,
+		config:     s.config,
+		maxTurns:   s.maxTurns,
+		keepTurns:  s.keepTurns,
+		summarised: s.summarised,
+		prefix:     s.prefix,
+		summary:    s.summary,
+	}
+}
+
+// summarize asks the model to fold turns into the current summary. The
+// usage is returned even if the summary is unusable.
+func (s *Summarizer) summarize(ctx context.Context, turns [][]Message) (string, Usage, error) {
+	var transcript strings.Builder
+	if s.summary != "" {
+		fmt.Fprintf(&transcript, "Summary so far:\n%s\n\nLater messages:\n", s.summary)
+	}
+	for _, msg := range joinTurns(nil, turns) {
+		if msg.Content == "" {
+			continue
+		}
+		fmt.Fprintf(&transcript, "%s: %s\n", msg.Role, msg.Content)
+	}
+
+	messages := []Message{
+		{Role: "system", Content: "Summarise the conversation below for your own future reference. Keep facts, decisions, names and open questions. Be concise."},
+		{Role: "user", Content: transcript.String()},
+	}
+	resp, err := complete(ctx, s.client, s.config, newRequest(s.config, messages))
+	if err != nil {
+		return "", Usage{}, err
+	}
+	if err := checkResponse(s.config.Model, resp); err != nil {
+		return "", resp.Usage, err
+	}
+	return resp.Content, resp.Usage, nil
+}
+
+// turnsFingerprint identifies a sequence of turns
+func turnsFingerprint(turns [][]Message) string {
+	data, _ := json.Marshal(turns)
+	sum := sha256.Sum256(data)
+	return string(sum[:])
+}
@@ -0,0 +1,207 @@
+package lib
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// chatMessages builds a system message followed by n user/assistant turns
+func chatMessages(n int) []Message {
+	messages := []Message{{Role: "system", Content: "System"}}
+	for i := 1; i <= n; i++ {
+		messages = append(messages,
+			Message{Role: "user", Content: fmt.Sprintf("question %d", i)},
+			Message{Role: "assistant", Content: fmt.Sprintf("answer %d", i)},
+		)
+	}
+	return messages
+}
+
+func TestSplitTurns(t *testing.T) {
+	messages := []Message{
+		{Role: "system", Content: "System"},
+		{Role: "user", Content: "q1"},
+		{Role: "assistant", ToolCalls: []ToolCall{{ID: "1"}}},
+		{Role: "tool", ToolCallID: "1"},
+		{Role: "assistant", Content: "a1"},
+		{Role: "user", Content: "q2"},
+	}
+
+	system, turns := splitTurns(messages)
+	if len(system) != 1 || len(turns) != 2 || len(turns[0]) != 4 || len(turns[1]) != 1 {
+		t.Fatalf("Unexpected split: system %v, turns %v", system, turns)
+	}
+	if joined := joinTurns(system, turns); len(joined) != len(messages) {
+		t.Errorf("Expected %d messages after join, got %d", len(messages), len(joined))
+	}
+}
+
+func TestEstimateTokens(t *testing.T) {
+	short := EstimateTokens([]Message{{Role: "user", Content: "hi"}})
+	long := EstimateTokens([]Message{{Role: "user", Content: strings.Repeat("word ", 400)}})
+
+	if short < 5 || short > 10 {
+		t.Errorf("Expected a small estimate for a short message, got %d", short)
+	}
+	if long < 450 || long > 550 {
+		t.Errorf("Expected about 500 tokens for 2000 characters, got %d", long)
+	}
+}
+
+func TestSlidingWindow(t *testing.T) {
+	req := &Request{Messages: append(chatMessages(5), Message{Role: "user", Content: "question 6"})}
+
+	messages, _, err := SlidingWindow{MaxTurns: 3}.Trim(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Trim failed: %v", err)
+	}
+
+	// system + turns 4 and 5 + pending question
+	if len(messages) != 6 {
+		t.Fatalf("Expected 6 messages, got %+v", messages)
+	}
+	if messages[0].Role != "system" || messages[1].Content != "question 4" || messages[5].Content != "question 6" {
+		t.Errorf("Unexpected window: %+v", messages)
+	}
+}
+
+func TestTokenBudget(t *testing.T) {
+	req := &Request{Model: "gpt-4o", Messages: append(chatMessages(20), Message{Role: "user", Content: "last"})}
+
+	budget := EstimateTokens(req.Messages[:1]) + EstimateTokens(req.Messages[len(req.Messages)-5:])
+	messages, _, err := TokenBudget{MaxTokens: budget}.Trim(context.Background(), req)
+	if err != nil {
+		t.Fatalf("Trim failed: %v", err)
+	}
+
+	if EstimateTokens(messages) > budget {
+		t.Errorf("Expected estimate within budget %d, got %d", budget, EstimateTokens(messages))
+	}
+	if messages[0].Role != "system" || messages[len(messages)-1].Content != "last" {
+		t.Errorf("Expected system message and pending turn to be kept, got %+v", messages)
+	}
+	if len(messages) >= len(req.Messages) {
+		t.Error("Expected older turns to be dropped")
+	}
+
+	// With no explicit budget, gpt-4o's context window easily fits
+	messages, _, _ = TokenBudget{}.Trim(context.Background(), req)
+	if len(messages) != len(req.Messages) {
+		t.Errorf("Expected no trimming within the model context window, got %d messages", len(messages))
+	}
+}
+
+func TestSummarizer(t *testing.T) {
+	summaries := &fakeProvider{
+		responses: []*Response{
+			{ID: "s1", Content: "first summary", FinishReason: "stop", Usage: Usage{TotalTokens: 12}},
+			{ID: "s2", Content: "second summary", FinishReason: "stop"},
+		},
+	}
+	summarizer := NewSummarizer(summaries, DefaultConfig(), 4, 2)
+	ctx := context.Background()
+
+	// 4 turns fit without summarising
+	messages, _, err := summarizer.Trim(ctx, &Request{Messages: append(chatMessages(3), Message{Role: "user", Content: "question 4"})})
+	if err != nil || len(messages) != 8 || len(summaries.requests) != 0 {
+		t.Fatalf("Expected no summary for 4 turns, got %d messages, %d requests, err %v", len(messages), len(summaries.requests), err)
+	}
+
+	// 5 turns: turns 1-3 are summarised, turns 4 and 5 are kept
+	messages, usage, err := summarizer.Trim(ctx, &Request{Messages: append(chatMessages(4), Message{Role: "user", Content: "question 5"})})
+	if err != nil {
+		t.Fatalf("Trim failed: %v", err)
+	}
+	if usage.TotalTokens != 12 {
+		t.Errorf("Expected the summary's usage to be reported, got %+v", usage)
+	}
+	if len(messages) != 5 || messages[0].Content != "System" || !strings.Contains(messages[1].Content, "first summary") || messages[2].Content != "question 4" {
+		t.Fatalf("Unexpected summarised messages: %+v", messages)
+	}
+	if !strings.Contains(summaries.requests[0].Messages[1].Content, "user: question 3") {
+		t.Errorf("Expected transcript to include turn 3, got %q", summaries.requests[0].Messages[1].Content)
+	}
+
+	// The cached summary is reused until the window fills again
+	summarizer.Trim(ctx, &Request{Messages: append(chatMessages(6), Message{Role: "user", Content: "question 7"})})
+	if len(summaries.requests) != 1 {
+		t.Errorf("Expected cached summary to be reused, got %d requests", len(summaries.requests))
+	}
+
+	messages, _, _ = summarizer.Trim(ctx, &Request{Messages: append(chatMessages(7), Message{Role: "user", Content: "question 8"})})
+	if len(summaries.requests) != 2 || !strings.Contains(summaries.requests[1].Messages[1].Content, "Summary so far:\nfirst summary") {
+		t.Fatalf("Expected incremental summary request, got %+v", summaries.requests)
+	}
+	if !strings.Contains(messages[1].Content, "second summary") || messages[2].Content != "question 7" {
+		t.Errorf("Unexpected messages after second summary: %+v", messages)
+	}
+}
+
+func TestConversation_ForkSummarizer(t *testing.T) {
+	summaries := &fakeProvider{}
+	conv := NewConversation(&fakeProvider{}, DefaultConfig(), "System")
+	conv.SetContextStrategy(NewSummarizer(summaries, DefaultConfig(), 2, 1))
+	ctx := context.Background()
+
+	for i := 1; i <= 3; i++ {
+		conv.SendMessage(ctx, fmt.Sprintf("question %d", i))
+	}
+	if len(summaries.requests) != 1 {
+		t.Fatalf("Expected 1 summary before forking, got %d", len(summaries.requests))
+	}
+
+	// The branches diverge; each keeps its own summary instead of
+	// invalidating the other's on every turn
+	fork := conv.Fork()
+	for i := 4; i <= 6; i++ {
+		conv.SendMessage(ctx, fmt.Sprintf("question %d", i))
+		fork.SendMessage(ctx, fmt.Sprintf("fork question %d", i))
+	}
+	if len(summaries.requests) != 3 {
+		t.Errorf("Expected one more summary per branch, got %d in total", len(summaries.requests))
+	}
+}
+
+func TestConversation_SummarizerUsage(t *testing.T) {
+	reply := func(content string) Provider {
+		return funcProvider(func(ctx context.Context, req *Request) (*Response, error) {
+			return &Response{ID: "1", Content: content, FinishReason: "stop", Usage: Usage{PromptTokens: 7, CompletionTokens: 3, TotalTokens: 10}}, nil
+		})
+	}
+	conv := NewConversation(reply("ok"), DefaultConfig(), "System")
+	conv.SetContextStrategy(NewSummarizer(reply("summary"), DefaultConfig(), 2, 1))
+
+	for i := 1; i <= 3; i++ {
+		if _, err := conv.SendMessage(context.Background(), fmt.Sprintf("question %d", i)); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+	}
+
+	// Three replies and the summary made before the third
+	expected := Usage{PromptTokens: 28, CompletionTokens: 12, TotalTokens: 40}
+	if got := conv.Usage(); got != expected {
+		t.Errorf("Expected usage %+v including the summary, got %+v", expected, got)
+	}
+}
+
+func TestConversation_ContextStrategy(t *testing.T) {
+	provider := &fakeProvider{}
+	conv := NewConversation(provider, DefaultConfig(), "System")
+	conv.SetContextStrategy(SlidingWindow{MaxTurns: 2})
+
+	for i := 0; i < 4; i++ {
+		if _, err := conv.SendMessage(context.Background(), fmt.Sprintf("message %d", i)); err != nil {
+			t.Fatalf("SendMessage failed: %v", err)
+		}
+	}
+
+	last := provider.requests[len(provider.requests)-1].Messages
+	if len(last) != 4 || last[0].Role != "system" || last[1].Content != "message 2" {
+		t.Errorf("Expected trimmed request, got %+v", last)
+	}
+	if len(conv.GetHistory()) != 9 {
+		t.Errorf("Expected full history to be kept, got %d messages", len(conv.GetHistory()))
+	}
+}